@@ -0,0 +1,91 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"os"
+
+	"go.yaml.in/yaml/v2"
+	"libvirt.org/go/libvirt"
+)
+
+const (
+	usernameEnv = "LIBVIRTD_EXPORTER_AUTH_USERNAME"
+	passwordEnv = "LIBVIRTD_EXPORTER_AUTH_PASSWORD"
+)
+
+// Credentials are used to authenticate against libvirt daemons that
+// require SASL or username and password authentication.
+type Credentials struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// LoadCredentials reads the credentials from the given YAML file, if any,
+// with the environment taking precedence over the file.  It returns nil if
+// no credentials were configured at all.
+func LoadCredentials(path string) (*Credentials, error) {
+	creds := &Credentials{}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		err = yaml.UnmarshalStrict(data, creds)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if username, ok := os.LookupEnv(usernameEnv); ok {
+		creds.Username = username
+	}
+	if password, ok := os.LookupEnv(passwordEnv); ok {
+		creds.Password = password
+	}
+
+	if creds.Username == "" && creds.Password == "" {
+		return nil, nil
+	}
+
+	return creds, nil
+}
+
+// auth answers the credential prompts of libvirt with the credentials.
+func (c *Credentials) auth() *libvirt.ConnectAuth {
+	return &libvirt.ConnectAuth{
+		CredType: []libvirt.ConnectCredentialType{
+			libvirt.CRED_AUTHNAME,
+			libvirt.CRED_USERNAME,
+			libvirt.CRED_PASSPHRASE,
+		},
+		Callback: func(creds []*libvirt.ConnectCredential) {
+			for _, cred := range creds {
+				switch cred.Type {
+				case libvirt.CRED_AUTHNAME, libvirt.CRED_USERNAME:
+					cred.Result = c.Username
+				case libvirt.CRED_PASSPHRASE:
+					cred.Result = c.Password
+				default:
+					continue
+				}
+
+				cred.ResultLen = len(cred.Result)
+			}
+		},
+	}
+}
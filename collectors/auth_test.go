@@ -0,0 +1,195 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"libvirt.org/go/libvirt"
+)
+
+// setTestEnv sets the variable for the duration of the test, or unsets it
+// if value is nil.
+func setTestEnv(t *testing.T, name string, value *string) {
+	t.Helper()
+
+	// NOTE: Setenv restores the variable once the test is done.
+	t.Setenv(name, "")
+	if value == nil {
+		err := os.Unsetenv(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	t.Setenv(name, *value)
+}
+
+func TestLoadCredentials(t *testing.T) {
+	ptr := func(s string) *string {
+		return &s
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		username *string
+		password *string
+		expected *Credentials
+	}{
+		{
+			name: "nothing set",
+		},
+		{
+			name:     "empty file",
+			file:     "{}\n",
+			expected: nil,
+		},
+		{
+			name:     "file",
+			file:     "username: exporter\npassword: secret\n",
+			expected: &Credentials{Username: "exporter", Password: "secret"},
+		},
+		{
+			name:     "environment",
+			username: ptr("exporter"),
+			password: ptr("secret"),
+			expected: &Credentials{Username: "exporter", Password: "secret"},
+		},
+		{
+			name:     "environment over file",
+			file:     "username: exporter\npassword: secret\n",
+			password: ptr("rotated"),
+			expected: &Credentials{Username: "exporter", Password: "rotated"},
+		},
+		{
+			name:     "empty environment over file",
+			file:     "username: exporter\npassword: secret\n",
+			username: ptr(""),
+			expected: &Credentials{Username: "", Password: "secret"},
+		},
+		{
+			name:     "password only",
+			password: ptr("secret"),
+			expected: &Credentials{Password: "secret"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestEnv(t, usernameEnv, tt.username)
+			setTestEnv(t, passwordEnv, tt.password)
+
+			path := ""
+			if tt.file != "" {
+				path = filepath.Join(t.TempDir(), "auth.yaml")
+				err := os.WriteFile(path, []byte(tt.file), 0o600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			creds, err := LoadCredentials(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case tt.expected == nil && creds != nil:
+				t.Errorf("got credentials %+v, want none", *creds)
+			case tt.expected != nil && creds == nil:
+				t.Errorf("got no credentials, want %+v", *tt.expected)
+			case tt.expected != nil && *creds != *tt.expected:
+				t.Errorf("got credentials %+v, want %+v", *creds, *tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadCredentialsInvalid(t *testing.T) {
+	setTestEnv(t, usernameEnv, nil)
+	setTestEnv(t, passwordEnv, nil)
+
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"unknown field", "user: exporter\n"},
+		{"invalid yaml", "username: [\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "auth.yaml")
+			err := os.WriteFile(path, []byte(tt.file), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = LoadCredentials(path)
+			if err == nil {
+				t.Error("loaded invalid credentials")
+			}
+		})
+	}
+
+	_, err := LoadCredentials(filepath.Join(t.TempDir(), "missing.yaml"))
+	if !os.IsNotExist(err) {
+		t.Errorf("got error %v loading a missing file, want it not to exist", err)
+	}
+}
+
+func TestCredentialsAuth(t *testing.T) {
+	creds := &Credentials{Username: "exporter", Password: "secret"}
+	auth := creds.auth()
+
+	expectedTypes := []libvirt.ConnectCredentialType{
+		libvirt.CRED_AUTHNAME,
+		libvirt.CRED_USERNAME,
+		libvirt.CRED_PASSPHRASE,
+	}
+	if !slices.Equal(auth.CredType, expectedTypes) {
+		t.Errorf("answers credential types %v, want %v", auth.CredType, expectedTypes)
+	}
+
+	tests := []struct {
+		credType libvirt.ConnectCredentialType
+		result   string
+	}{
+		{libvirt.CRED_AUTHNAME, "exporter"},
+		{libvirt.CRED_USERNAME, "exporter"},
+		{libvirt.CRED_PASSPHRASE, "secret"},
+		{libvirt.CRED_ECHOPROMPT, ""},
+		{libvirt.CRED_REALM, ""},
+	}
+
+	prompts := make([]*libvirt.ConnectCredential, 0, len(tests))
+	for _, tt := range tests {
+		prompts = append(prompts, &libvirt.ConnectCredential{Type: tt.credType})
+	}
+
+	auth.Callback(prompts)
+
+	for i, tt := range tests {
+		cred := prompts[i]
+		if cred.Result != tt.result || cred.ResultLen != len(tt.result) {
+			t.Errorf("answered credential type %d with %q of length %d, want %q", tt.credType, cred.Result, cred.ResultLen, tt.result)
+		}
+	}
+}
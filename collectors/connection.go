@@ -0,0 +1,312 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"github.com/prometheus/client_golang/prometheus"
+	"libvirt.org/go/libvirt"
+)
+
+// Connection owns the libvirt connection shared by every collector and
+// re-establishes it, with exponential backoff, whenever it is lost.  It is
+// also a collector itself, reporting whether libvirt is reachable and how
+// many errors the collectors have run into.
+type Connection struct {
+	prometheus.Collector
+
+	logger  *slog.Logger
+	uri     string
+	options ConnectionOptions
+
+	// dial opens a new connection, it is only swapped out in tests.
+	dial func() (Connect, error)
+
+	mu          sync.Mutex
+	connection  Connect
+	backoff     *backoff.Backoff
+	nextAttempt time.Time
+
+	// lastUsed is when a connection was last asked for, in Unix
+	// nanoseconds, so that the pool can close idle ones.
+	lastUsed atomic.Int64
+
+	inflightMu sync.Mutex
+	inflight   map[string]*inflightScrape
+
+	// values holds the state collectors keep per connection, see value.
+	values sync.Map
+
+	Up     *prometheus.Desc
+	Errors *prometheus.CounterVec
+}
+
+// ConnectionOptions controls how connections to libvirt are opened.
+type ConnectionOptions struct {
+	// ReadOnly opens the connection read-only, which is all the
+	// collectors need and works against libvirt-sock-ro.
+	ReadOnly bool
+
+	// Credentials, if set, are handed to libvirt when it asks for them.
+	Credentials *Credentials
+}
+
+func NewConnection(logger *slog.Logger, uri string, options ConnectionOptions) *Connection {
+	c := &Connection{
+		logger:   logger,
+		uri:      uri,
+		options:  options,
+		inflight: map[string]*inflightScrape{},
+		backoff: &backoff.Backoff{
+			Min:    time.Second,
+			Max:    5 * time.Minute,
+			Factor: 2,
+			Jitter: true,
+		},
+
+		Up: prometheus.NewDesc(
+			"libvirtd_up",
+			"whether libvirt could be reached",
+			nil, nil,
+		),
+		Errors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "libvirtd_scrape_errors_total",
+				Help: "errors returned while scraping libvirt (virErrorNumber enum)",
+			},
+			[]string{"collector", "code"},
+		),
+	}
+	c.dial = c.open
+	c.touch()
+
+	return c
+}
+
+// value returns the value kept under key, storing the one returned by init
+// if there is none yet.  Collectors are created anew on every probe and
+// reload, this lets them keep state for as long as the connection lives.
+func (c *Connection) value(key any, init func() any) any {
+	if v, ok := c.values.Load(key); ok {
+		return v
+	}
+
+	v, _ := c.values.LoadOrStore(key, init())
+	return v
+}
+
+func (c *Connection) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.Up
+	c.Errors.Describe(ch)
+}
+
+func (c *Connection) Collect(ch chan<- prometheus.Metric) {
+	c.CollectWithContext(context.Background(), ch)
+}
+
+// CollectWithContext reports whether libvirt can be reached.  Connecting
+// goes through the same deadline and in-flight guard as the collectors so
+// that a hung reconnect, to an unreachable remote target for instance, does
+// not hold up the scrape.
+func (c *Connection) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	up := float64(0)
+
+	err := runScrape(ctx, c, "connection", ch, func(context.Context, Connect, chan<- prometheus.Metric) error {
+		return nil
+	})
+	if err != nil {
+		c.logger.Error("Failed to connect to libvirt", "err", err)
+		c.CountError("connection", err)
+	} else {
+		up = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.Up,
+		prometheus.GaugeValue,
+		up,
+	)
+	c.Errors.Collect(ch)
+}
+
+// CountError records an error returned to the given collector.
+func (c *Connection) CountError(collector string, err error) {
+	c.Errors.WithLabelValues(collector, errorCode(err)).Inc()
+}
+
+// URI returns the libvirt connection URI this connection is bound to.
+func (c *Connection) URI() string {
+	return c.uri
+}
+
+// Connect returns a live libvirt connection, reconnecting first if the
+// current one is dead.  The caller owns a reference to the returned
+// connection and must Close it once done so that a concurrent reconnect
+// never frees it while it is still in use.
+func (c *Connection) Connect() (Connect, error) {
+	c.touch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connection != nil {
+		alive, err := c.connection.IsAlive()
+		if err == nil && alive {
+			return c.ref()
+		}
+
+		if err != nil {
+			c.logger.Error("Failed to check if connection is alive", "err", err)
+		}
+
+		_, err = c.connection.Close()
+		if err != nil {
+			c.logger.Error("Failed to close connection", "err", err)
+		}
+		c.connection = nil
+	}
+
+	if wait := time.Until(c.nextAttempt); wait > 0 {
+		return nil, fmt.Errorf("waiting %s before reconnecting to %s", wait.Round(time.Millisecond), c.uri)
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		c.nextAttempt = time.Now().Add(c.backoff.Duration())
+		return nil, err
+	}
+
+	if c.backoff.Attempt() > 0 {
+		c.logger.Info("Reconnected to libvirt", "uri", c.uri)
+	}
+	c.backoff.Reset()
+	c.nextAttempt = time.Time{}
+	c.connection = conn
+
+	return c.ref()
+}
+
+// Close releases the connection held by the manager.
+func (c *Connection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connection == nil {
+		return nil
+	}
+
+	_, err := c.connection.Close()
+	c.connection = nil
+
+	return err
+}
+
+// touch marks the connection as used now.
+func (c *Connection) touch() {
+	c.lastUsed.Store(now().UnixNano())
+}
+
+// idleFor returns how long the connection has not been used for.
+func (c *Connection) idleFor() time.Duration {
+	return now().Sub(time.Unix(0, c.lastUsed.Load()))
+}
+
+// inflightScrape is a scrape of a collector waiting on libvirt.
+type inflightScrape struct {
+	done chan struct{}
+
+	// expired is set once the scrape outlived its deadline and was left to
+	// finish in the background.
+	expired atomic.Bool
+}
+
+// acquire marks a scrape of the named collector as in flight, waiting for
+// the previous one to finish first.  It gives up once ctx is done, or right
+// away if the previous scrape outlived its deadline as libvirt is then
+// likely hung.
+func (c *Connection) acquire(ctx context.Context, collector string) (*inflightScrape, error) {
+	for {
+		c.inflightMu.Lock()
+		previous, ok := c.inflight[collector]
+		if !ok {
+			scrape := &inflightScrape{done: make(chan struct{})}
+			c.inflight[collector] = scrape
+			c.inflightMu.Unlock()
+
+			return scrape, nil
+		}
+		c.inflightMu.Unlock()
+
+		if previous.expired.Load() {
+			return nil, errScrapeInProgress
+		}
+
+		select {
+		case <-previous.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// release ends a scrape of the named collector.
+func (c *Connection) release(collector string, scrape *inflightScrape) {
+	c.inflightMu.Lock()
+	delete(c.inflight, collector)
+	c.inflightMu.Unlock()
+
+	close(scrape.done)
+}
+
+func (c *Connection) open() (Connect, error) {
+	var conn *libvirt.Connect
+	var err error
+
+	switch {
+	case c.options.Credentials != nil:
+		var flags libvirt.ConnectFlags
+		if c.options.ReadOnly {
+			flags |= libvirt.CONNECT_RO
+		}
+
+		conn, err = libvirt.NewConnectWithAuth(c.uri, c.options.Credentials.auth(), flags)
+	case c.options.ReadOnly:
+		conn, err = libvirt.NewConnectReadOnly(c.uri)
+	default:
+		conn, err = libvirt.NewConnect(c.uri)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return libvirtConnect{conn}, nil
+}
+
+func (c *Connection) ref() (Connect, error) {
+	err := c.connection.Ref()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.connection, nil
+}
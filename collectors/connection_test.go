@@ -0,0 +1,248 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestConnectionBackoff(t *testing.T) {
+	errDial := errors.New("connection refused")
+
+	// Each step connects once, after the backoff window elapsed if elapse
+	// is set.
+	type step struct {
+		dialErr   error
+		elapse    bool
+		wantDials int
+		wantErr   bool
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "no redial within the backoff window",
+			steps: []step{
+				{dialErr: errDial, wantDials: 1, wantErr: true},
+				{dialErr: errDial, wantDials: 1, wantErr: true},
+				{dialErr: errDial, wantDials: 1, wantErr: true},
+			},
+		},
+		{
+			name: "redial once the backoff window elapsed",
+			steps: []step{
+				{dialErr: errDial, wantDials: 1, wantErr: true},
+				{dialErr: errDial, elapse: true, wantDials: 2, wantErr: true},
+				{dialErr: errDial, wantDials: 2, wantErr: true},
+			},
+		},
+		{
+			name: "reset after a successful dial",
+			steps: []step{
+				{dialErr: errDial, wantDials: 1, wantErr: true},
+				{dialErr: errDial, elapse: true, wantDials: 2, wantErr: true},
+				{elapse: true, wantDials: 3},
+				{wantDials: 3},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dialErr error
+			dials := 0
+
+			c := newFakeConnection(&fakeConnect{})
+			c.dial = func() (Connect, error) {
+				dials++
+				if dialErr != nil {
+					return nil, dialErr
+				}
+
+				return &fakeConnect{}, nil
+			}
+
+			for i, s := range tt.steps {
+				dialErr = s.dialErr
+				if s.elapse {
+					c.nextAttempt = time.Now()
+				}
+
+				conn, err := c.Connect()
+				if (err != nil) != s.wantErr {
+					t.Fatalf("step %d: got error %v, want error %t", i, err, s.wantErr)
+				}
+				if err == nil {
+					_, _ = conn.Close()
+				}
+
+				if dials != s.wantDials {
+					t.Fatalf("step %d: dialed %d times, want %d", i, dials, s.wantDials)
+				}
+			}
+		})
+	}
+}
+
+func TestConnectionBackoffReset(t *testing.T) {
+	fail := true
+
+	c := newFakeConnection(&fakeConnect{})
+	c.dial = func() (Connect, error) {
+		if fail {
+			return nil, errors.New("connection refused")
+		}
+
+		return &fakeConnect{}, nil
+	}
+
+	// NOTE: Back off far enough for the window to outgrow the minimum.
+	for range 4 {
+		c.nextAttempt = time.Now()
+		_, _ = c.Connect()
+	}
+	if window := time.Until(c.nextAttempt); window <= c.backoff.Min {
+		t.Fatalf("backoff window is %s after 4 failures, want more than %s", window, c.backoff.Min)
+	}
+
+	fail = false
+	c.nextAttempt = time.Now()
+	_, err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.backoff.Attempt() != 0 {
+		t.Errorf("backoff is at attempt %v after a successful dial, want 0", c.backoff.Attempt())
+	}
+
+	// A connection that dies starts backing off from the minimum again.
+	c.connection = &fakeConnect{dead: true}
+	fail = true
+	_, _ = c.Connect()
+	if window := time.Until(c.nextAttempt); window > c.backoff.Min {
+		t.Errorf("backoff window is %s after a reset, want at most %s", window, c.backoff.Min)
+	}
+}
+
+func TestConnectionSerializesReconnects(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialErr error
+	}{
+		{name: "successful dial"},
+		{name: "failing dial", dialErr: errors.New("connection refused")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dials atomic.Int32
+
+			c := newFakeConnection(&fakeConnect{})
+			c.dial = func() (Connect, error) {
+				dials.Add(1)
+				time.Sleep(10 * time.Millisecond)
+
+				if tt.dialErr != nil {
+					return nil, tt.dialErr
+				}
+
+				return &fakeConnect{}, nil
+			}
+
+			var wg sync.WaitGroup
+			for range 10 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					conn, err := c.Connect()
+					if err == nil {
+						_, _ = conn.Close()
+					}
+				}()
+			}
+			wg.Wait()
+
+			if got := dials.Load(); got != 1 {
+				t.Errorf("dialed %d times, want 1", got)
+			}
+		})
+	}
+}
+
+func TestConnectionCollectHungDial(t *testing.T) {
+	unblock := make(chan struct{})
+	var hung atomic.Bool
+	hung.Store(true)
+
+	c := newFakeConnection(&fakeConnect{})
+	c.dial = func() (Connect, error) {
+		if hung.Load() {
+			<-unblock
+		}
+
+		return &fakeConnect{}, nil
+	}
+
+	expected := func(up string) string {
+		return `
+# HELP libvirtd_up whether libvirt could be reached
+# TYPE libvirtd_up gauge
+libvirtd_up ` + up + `
+`
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := testutil.CollectAndCompare(WithContext(ctx, c), strings.NewReader(expected("0")), "libvirtd_up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("scrape took %s while dialing hung, want it bounded by its deadline", elapsed)
+	}
+
+	// NOTE: The hung dial is not piled up on.
+	err = testutil.CollectAndCompare(WithContext(context.Background(), c), strings.NewReader(expected("0")), "libvirtd_up")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := testutil.ToFloat64(c.Errors.WithLabelValues("connection", "timeout"))
+	if count != 2 {
+		t.Errorf("counted %v timeouts, want 2", count)
+	}
+
+	hung.Store(false)
+	close(unblock)
+	waitReleased(t, c, "connection")
+
+	err = testutil.CollectAndCompare(WithContext(context.Background(), c), strings.NewReader(expected("1")), "libvirtd_up")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+
+	"libvirt.org/go/libvirt"
+)
+
+// domainListStates maps the states domains can be filtered on to the flags
+// listing them.
+var domainListStates = map[string]libvirt.ConnectListAllDomainsFlags{
+	"running": libvirt.CONNECT_LIST_DOMAINS_RUNNING,
+	"paused":  libvirt.CONNECT_LIST_DOMAINS_PAUSED,
+	"shutoff": libvirt.CONNECT_LIST_DOMAINS_SHUTOFF,
+	"other":   libvirt.CONNECT_LIST_DOMAINS_OTHER,
+}
+
+// DomainMatcher holds criteria domains are matched on, those left unset
+// match every domain.  Each criterion matches a domain if any of its values
+// does, see DomainFilter for how criteria are combined.
+type DomainMatcher struct {
+	Names        *regexp.Regexp
+	UUIDs        []string
+	States       []string
+	NovaProjects []string
+	NovaFlavors  []string
+}
+
+// DomainFilter selects the domains to scrape.  A domain is scraped if it
+// matches every criterion set in Include and none of those set in Exclude.
+type DomainFilter struct {
+	Include DomainMatcher
+	Exclude DomainMatcher
+}
+
+// Validate checks that the filtered states are known and that they leave
+// some state to list domains in.
+func (f *DomainFilter) Validate() error {
+	states := slices.Concat(f.Include.States, f.Exclude.States)
+	for _, state := range states {
+		if _, ok := domainListStates[state]; !ok {
+			return fmt.Errorf("unknown domain state %q", state)
+		}
+	}
+
+	// NOTE: No flag at all lists domains in every state, rather than none.
+	if len(states) > 0 && f.listFlags() == 0 {
+		return fmt.Errorf("the excluded domain states %v leave no state to include", f.Exclude.States)
+	}
+
+	return nil
+}
+
+// IsEmpty returns whether the filter selects every domain.
+func (f *DomainFilter) IsEmpty() bool {
+	return f.Include.isEmpty() && f.Exclude.isEmpty()
+}
+
+// needsNova returns whether the filter needs the Nova metadata of domains.
+func (f *DomainFilter) needsNova() bool {
+	return len(f.Include.NovaProjects) > 0 || len(f.Include.NovaFlavors) > 0 ||
+		len(f.Exclude.NovaProjects) > 0 || len(f.Exclude.NovaFlavors) > 0
+}
+
+// listFlags returns the flags listing the domains in the filtered states.
+func (f *DomainFilter) listFlags() libvirt.ConnectListAllDomainsFlags {
+	if len(f.Include.States) == 0 && len(f.Exclude.States) == 0 {
+		return 0
+	}
+
+	var flags libvirt.ConnectListAllDomainsFlags
+	for state, flag := range domainListStates {
+		included := len(f.Include.States) == 0 || slices.Contains(f.Include.States, state)
+		if included && !slices.Contains(f.Exclude.States, state) {
+			flags |= flag
+		}
+	}
+
+	return flags
+}
+
+// matches returns whether the domain, already listed in one of the
+// filtered states, is selected.  metadata is left empty unless the filter
+// needs Nova metadata.
+func (f *DomainFilter) matches(uuid string, name string, metadata *NovaMetadata) bool {
+	include := f.Include
+	if include.Names != nil && !include.Names.MatchString(name) {
+		return false
+	}
+	if len(include.UUIDs) > 0 && !slices.Contains(include.UUIDs, uuid) {
+		return false
+	}
+	if len(include.NovaProjects) > 0 && !slices.Contains(include.NovaProjects, metadata.Project.UUID) {
+		return false
+	}
+	if len(include.NovaFlavors) > 0 && !slices.Contains(include.NovaFlavors, metadata.Flavor.Name) {
+		return false
+	}
+
+	exclude := f.Exclude
+	if exclude.Names != nil && exclude.Names.MatchString(name) {
+		return false
+	}
+	if slices.Contains(exclude.UUIDs, uuid) {
+		return false
+	}
+	if slices.Contains(exclude.NovaProjects, metadata.Project.UUID) {
+		return false
+	}
+	if slices.Contains(exclude.NovaFlavors, metadata.Flavor.Name) {
+		return false
+	}
+
+	return true
+}
+
+func (m *DomainMatcher) isEmpty() bool {
+	return m.Names == nil && len(m.UUIDs) == 0 && len(m.States) == 0 &&
+		len(m.NovaProjects) == 0 && len(m.NovaFlavors) == 0
+}
@@ -0,0 +1,187 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"libvirt.org/go/libvirt"
+)
+
+func TestDomainStatsCollectorFilter(t *testing.T) {
+	web := &fakeDomain{uuid: "0b1f7d5e-web", name: "instance-00000001", metadata: novaMetadata}
+	db := &fakeDomain{uuid: "5e4d3c2b-db", name: "instance-00000002"}
+	build := &fakeDomain{uuid: "9a8b7c6d-build", name: "ci-build-1"}
+
+	tests := []struct {
+		name      string
+		filter    DomainFilter
+		listFlags libvirt.ConnectListAllDomainsFlags
+		expected  []Domain
+	}{
+		{
+			name:     "empty",
+			expected: nil,
+		},
+		{
+			name: "include names",
+			filter: DomainFilter{
+				Include: DomainMatcher{Names: regexp.MustCompile("^(?:instance-.*)$")},
+			},
+			expected: []Domain{web, db},
+		},
+		{
+			name: "exclude uuids",
+			filter: DomainFilter{
+				Exclude: DomainMatcher{UUIDs: []string{"5e4d3c2b-db"}},
+			},
+			expected: []Domain{web, build},
+		},
+		{
+			name: "include states",
+			filter: DomainFilter{
+				Include: DomainMatcher{States: []string{"running", "paused"}},
+				Exclude: DomainMatcher{States: []string{"paused"}},
+			},
+			listFlags: libvirt.CONNECT_LIST_DOMAINS_RUNNING,
+			expected:  []Domain{web, db, build},
+		},
+		{
+			name: "include nova projects",
+			filter: DomainFilter{
+				Include: DomainMatcher{NovaProjects: []string{"4f5e6d7c-project"}},
+			},
+			expected: []Domain{web},
+		},
+		{
+			name: "exclude nova flavors",
+			filter: DomainFilter{
+				Exclude: DomainMatcher{NovaFlavors: []string{"m1.small"}},
+			},
+			expected: []Domain{db, build},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConnect{domains: []Domain{web, db, build}}
+
+			opts := DefaultOptions()
+			opts.DomainFilter = tt.filter
+
+			c := newTestDomainStatsCollector(conn, opts)
+			testutil.CollectAndCount(c)
+
+			if conn.listFlags != tt.listFlags {
+				t.Errorf("listed domains with flags %#x, want %#x", conn.listFlags, tt.listFlags)
+			}
+			if !slices.Equal(conn.requested, tt.expected) {
+				t.Errorf("requested stats of %v, want %v", conn.requested, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDomainStatsCollectorFilterNoMatch(t *testing.T) {
+	conn := &fakeConnect{
+		domains: []Domain{&fakeDomain{uuid: testUUID, name: testDomain.name}},
+	}
+
+	opts := DefaultOptions()
+	opts.DomainFilter.Include.UUIDs = []string{"5e4d3c2b-db"}
+
+	c := newTestDomainStatsCollector(conn, opts)
+	testutil.CollectAndCount(c)
+
+	if conn.statsTypes != 0 {
+		t.Error("requested stats with no domain selected")
+	}
+}
+
+func TestDomainStatsCollectorFilterShutoff(t *testing.T) {
+	web := &fakeDomain{uuid: "0b1f7d5e-web", name: "instance-00000001", metadata: novaMetadata, inactive: true}
+	build := &fakeDomain{uuid: "9a8b7c6d-build", name: "ci-build-1", inactive: true}
+
+	conn := &fakeConnect{domains: []Domain{web, build}}
+
+	opts := DefaultOptions()
+	opts.DomainFilter.Include.NovaProjects = []string{"4f5e6d7c-project"}
+
+	c := newTestDomainStatsCollector(conn, opts)
+	testutil.CollectAndCount(c)
+
+	if expected := []Domain{web}; !slices.Equal(conn.requested, expected) {
+		t.Errorf("requested stats of %v, want %v", conn.requested, expected)
+	}
+	if count := testutil.CollectAndCount(c.connection.Errors); count != 0 {
+		t.Errorf("counted %d errors, want none for domains without Nova metadata", count)
+	}
+}
+
+func TestDomainFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  DomainFilter
+		invalid bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "known states",
+			filter: DomainFilter{
+				Include: DomainMatcher{States: []string{"running", "paused"}},
+				Exclude: DomainMatcher{States: []string{"paused"}},
+			},
+		},
+		{
+			name: "unknown state",
+			filter: DomainFilter{
+				Include: DomainMatcher{States: []string{"crashed"}},
+			},
+			invalid: true,
+		},
+		{
+			name: "included states excluded",
+			filter: DomainFilter{
+				Include: DomainMatcher{States: []string{"running"}},
+				Exclude: DomainMatcher{States: []string{"running"}},
+			},
+			invalid: true,
+		},
+		{
+			name: "every state excluded",
+			filter: DomainFilter{
+				Exclude: DomainMatcher{States: []string{"running", "paused", "shutoff", "other"}},
+			},
+			invalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.filter.Validate()
+			if tt.invalid && err == nil {
+				t.Error("validated a filter listing no domain")
+			}
+			if !tt.invalid && err != nil {
+				t.Errorf("failed to validate the filter: %v", err)
+			}
+		})
+	}
+}
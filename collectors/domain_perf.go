@@ -0,0 +1,233 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"libvirt.org/go/libvirt"
+)
+
+// domainPerfEvent is a perf event libvirt can count for a domain, it is only
+// reported for the domains it is enabled on.
+type domainPerfEvent struct {
+	name      string
+	help      string
+	valueType prometheus.ValueType
+	scale     float64
+	value     func(perf *libvirt.DomainStatsPerf) (uint64, bool)
+}
+
+// domainPerfEvents are the perf events exported by the domain stats
+// collector, in the order of the libvirt documentation.
+var domainPerfEvents = []domainPerfEvent{
+	{
+		name:      "libvirtd_domain_perf_cmt_bytes",
+		help:      "usage of the last level cache in bytes",
+		valueType: prometheus.GaugeValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.Cmt, perf.CmtSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_mbmt_bytes_per_second",
+		help:      "total memory bandwidth from the last level cache in bytes per second",
+		valueType: prometheus.GaugeValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.Mbmt, perf.MbmtSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_mbml_bytes_per_second",
+		help:      "local memory bandwidth from the last level cache in bytes per second",
+		valueType: prometheus.GaugeValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.Mbml, perf.MbmlSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_cache_misses_total",
+		help:      "number of cache misses",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.CacheMisses, perf.CacheMissesSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_cache_references_total",
+		help:      "number of cache hits",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.CacheReferences, perf.CacheReferencesSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_instructions_total",
+		help:      "number of instructions",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.Instructions, perf.InstructionsSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_cpu_cycles_total",
+		help:      "number of cpu cycles",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.CpuCycles, perf.CpuCyclesSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_branch_instructions_total",
+		help:      "number of branch instructions",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.BranchInstructions, perf.BranchInstructionsSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_branch_misses_total",
+		help:      "number of branch misses",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.BranchMisses, perf.BranchMissesSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_bus_cycles_total",
+		help:      "number of bus cycles",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.BusCycles, perf.BusCyclesSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_stalled_cycles_frontend_total",
+		help:      "number of stalled cpu cycles in the frontend of the instruction pipeline",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.StalledCyclesFrontend, perf.StalledCyclesFrontendSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_stalled_cycles_backend_total",
+		help:      "number of stalled cpu cycles in the backend of the instruction pipeline",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.StalledCyclesBackend, perf.StalledCyclesBackendSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_ref_cpu_cycles_total",
+		help:      "number of cpu cycles unaffected by frequency scaling",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.RefCpuCycles, perf.RefCpuCyclesSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_cpu_clock_seconds_total",
+		help:      "cpu clock time in seconds",
+		valueType: prometheus.CounterValue,
+		scale:     nanoseconds,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.CpuClock, perf.CpuClockSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_task_clock_seconds_total",
+		help:      "task clock time in seconds",
+		valueType: prometheus.CounterValue,
+		scale:     nanoseconds,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.TaskClock, perf.TaskClockSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_page_faults_total",
+		help:      "number of page faults",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.PageFaults, perf.PageFaultsSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_context_switches_total",
+		help:      "number of context switches",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.ContextSwitches, perf.ContextSwitchesSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_cpu_migrations_total",
+		help:      "number of cpu migrations",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.CpuMigrations, perf.CpuMigrationsSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_minor_page_faults_total",
+		help:      "number of minor page faults",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.PageFaultsMin, perf.PageFaultsMinSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_major_page_faults_total",
+		help:      "number of major page faults",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.PageFaultsMaj, perf.PageFaultsMajSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_alignment_faults_total",
+		help:      "number of alignment faults",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.AlignmentFaults, perf.AlignmentFaultsSet
+		},
+	},
+	{
+		name:      "libvirtd_domain_perf_emulation_faults_total",
+		help:      "number of emulation faults",
+		valueType: prometheus.CounterValue,
+		scale:     1,
+		value: func(perf *libvirt.DomainStatsPerf) (uint64, bool) {
+			return perf.EmulationFaults, perf.EmulationFaultsSet
+		},
+	},
+}
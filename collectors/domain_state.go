@@ -0,0 +1,117 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"strconv"
+
+	"libvirt.org/go/libvirt"
+)
+
+// domainStates names the values of the virDomainState enum.
+var domainStates = map[libvirt.DomainState]string{
+	libvirt.DOMAIN_NOSTATE:     "nostate",
+	libvirt.DOMAIN_RUNNING:     "running",
+	libvirt.DOMAIN_BLOCKED:     "blocked",
+	libvirt.DOMAIN_PAUSED:      "paused",
+	libvirt.DOMAIN_SHUTDOWN:    "shutdown",
+	libvirt.DOMAIN_SHUTOFF:     "shutoff",
+	libvirt.DOMAIN_CRASHED:     "crashed",
+	libvirt.DOMAIN_PMSUSPENDED: "pmsuspended",
+}
+
+// domainStateReasons names the values of the virDomain*Reason enums, the
+// meaning of a reason depends on the state it comes with.
+var domainStateReasons = map[libvirt.DomainState]map[int]string{
+	libvirt.DOMAIN_NOSTATE: {
+		int(libvirt.DOMAIN_NOSTATE_UNKNOWN): "unknown",
+	},
+	libvirt.DOMAIN_RUNNING: {
+		int(libvirt.DOMAIN_RUNNING_UNKNOWN):            "unknown",
+		int(libvirt.DOMAIN_RUNNING_BOOTED):             "booted",
+		int(libvirt.DOMAIN_RUNNING_MIGRATED):           "migrated",
+		int(libvirt.DOMAIN_RUNNING_RESTORED):           "restored",
+		int(libvirt.DOMAIN_RUNNING_FROM_SNAPSHOT):      "from_snapshot",
+		int(libvirt.DOMAIN_RUNNING_UNPAUSED):           "unpaused",
+		int(libvirt.DOMAIN_RUNNING_MIGRATION_CANCELED): "migration_canceled",
+		int(libvirt.DOMAIN_RUNNING_SAVE_CANCELED):      "save_canceled",
+		int(libvirt.DOMAIN_RUNNING_WAKEUP):             "wakeup",
+		int(libvirt.DOMAIN_RUNNING_CRASHED):            "crashed",
+		int(libvirt.DOMAIN_RUNNING_POSTCOPY):           "postcopy",
+		int(libvirt.DOMAIN_RUNNING_POSTCOPY_FAILED):    "postcopy_failed",
+	},
+	libvirt.DOMAIN_BLOCKED: {
+		int(libvirt.DOMAIN_BLOCKED_UNKNOWN): "unknown",
+	},
+	libvirt.DOMAIN_PAUSED: {
+		int(libvirt.DOMAIN_PAUSED_UNKNOWN):         "unknown",
+		int(libvirt.DOMAIN_PAUSED_USER):            "user",
+		int(libvirt.DOMAIN_PAUSED_MIGRATION):       "migration",
+		int(libvirt.DOMAIN_PAUSED_SAVE):            "save",
+		int(libvirt.DOMAIN_PAUSED_DUMP):            "dump",
+		int(libvirt.DOMAIN_PAUSED_IOERROR):         "ioerror",
+		int(libvirt.DOMAIN_PAUSED_WATCHDOG):        "watchdog",
+		int(libvirt.DOMAIN_PAUSED_FROM_SNAPSHOT):   "from_snapshot",
+		int(libvirt.DOMAIN_PAUSED_SHUTTING_DOWN):   "shutting_down",
+		int(libvirt.DOMAIN_PAUSED_SNAPSHOT):        "snapshot",
+		int(libvirt.DOMAIN_PAUSED_CRASHED):         "crashed",
+		int(libvirt.DOMAIN_PAUSED_STARTING_UP):     "starting_up",
+		int(libvirt.DOMAIN_PAUSED_POSTCOPY):        "postcopy",
+		int(libvirt.DOMAIN_PAUSED_POSTCOPY_FAILED): "postcopy_failed",
+		int(libvirt.DOMAIN_PAUSED_API_ERROR):       "api_error",
+	},
+	libvirt.DOMAIN_SHUTDOWN: {
+		int(libvirt.DOMAIN_SHUTDOWN_UNKNOWN): "unknown",
+		int(libvirt.DOMAIN_SHUTDOWN_USER):    "user",
+	},
+	libvirt.DOMAIN_SHUTOFF: {
+		int(libvirt.DOMAIN_SHUTOFF_UNKNOWN):       "unknown",
+		int(libvirt.DOMAIN_SHUTOFF_SHUTDOWN):      "shutdown",
+		int(libvirt.DOMAIN_SHUTOFF_DESTROYED):     "destroyed",
+		int(libvirt.DOMAIN_SHUTOFF_CRASHED):       "crashed",
+		int(libvirt.DOMAIN_SHUTOFF_MIGRATED):      "migrated",
+		int(libvirt.DOMAIN_SHUTOFF_SAVED):         "saved",
+		int(libvirt.DOMAIN_SHUTOFF_FAILED):        "failed",
+		int(libvirt.DOMAIN_SHUTOFF_FROM_SNAPSHOT): "from_snapshot",
+		int(libvirt.DOMAIN_SHUTOFF_DAEMON):        "daemon",
+	},
+	libvirt.DOMAIN_CRASHED: {
+		int(libvirt.DOMAIN_CRASHED_UNKNOWN):  "unknown",
+		int(libvirt.DOMAIN_CRASHED_PANICKED): "panicked",
+	},
+	libvirt.DOMAIN_PMSUSPENDED: {
+		int(libvirt.DOMAIN_PMSUSPENDED_UNKNOWN): "unknown",
+	},
+}
+
+// domainStateName returns the name of the state, or its number if it is
+// not known.
+func domainStateName(state libvirt.DomainState) string {
+	if name, ok := domainStates[state]; ok {
+		return name
+	}
+
+	return strconv.Itoa(int(state))
+}
+
+// domainStateReasonName returns the name of the reason for entering the
+// state, or its number if it is not known.
+func domainStateReasonName(state libvirt.DomainState, reason int) string {
+	if name, ok := domainStateReasons[state][reason]; ok {
+		return name
+	}
+
+	return strconv.Itoa(reason)
+}
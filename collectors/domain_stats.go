@@ -15,72 +15,196 @@
 package collectors
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"log/slog"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"libvirt.org/go/libvirt"
 )
 
+// domainStatsGroups maps the toggleable groups of the domain stats collector
+// to the stats they request from libvirt.
+var domainStatsGroups = []struct {
+	name             string
+	statsTypes       libvirt.DomainStatsTypes
+	isDefaultEnabled bool
+}{
+	{"state", libvirt.DOMAIN_STATS_STATE, defaultEnabled},
+	{"cpu", libvirt.DOMAIN_STATS_CPU_TOTAL, defaultEnabled},
+	{"balloon", libvirt.DOMAIN_STATS_BALLOON, defaultEnabled},
+	{"vcpu", libvirt.DOMAIN_STATS_VCPU, defaultEnabled},
+	{"net", libvirt.DOMAIN_STATS_INTERFACE, defaultEnabled},
+	{"block", libvirt.DOMAIN_STATS_BLOCK, defaultEnabled},
+	{"perf", libvirt.DOMAIN_STATS_PERF, defaultEnabled},
+	{"iothread", libvirt.DOMAIN_STATS_IOTHREAD, defaultEnabled},
+	{"memory", libvirt.DOMAIN_STATS_MEMORY, defaultEnabled},
+	// NOTE: Querying the dirty rate and the hypervisor statistics waits on
+	//       the QEMU monitor of every running domain.
+	{"dirtyrate", libvirt.DOMAIN_STATS_DIRTYRATE, defaultDisabled},
+	{"vm", libvirt.DOMAIN_STATS_VM, defaultDisabled},
+}
+
+func init() {
+	registerCollector("domain_stats", defaultEnabled, func(logger *slog.Logger, connection *Connection, opts *Options) prometheus.Collector {
+		return NewDomainStatsCollector(logger, connection, opts)
+	})
+
+	for _, group := range domainStatsGroups {
+		registerCollectorGroup("domain_stats", group.name, group.isDefaultEnabled)
+	}
+	// NOTE: The info metrics come from the domain XML, the disk errors and
+	//       the iothread pinning from their own calls, each costing an
+	//       extra call per domain.
+	registerCollectorGroup("domain_stats", "info", defaultDisabled)
+	registerCollectorGroup("domain_stats", "block_errors", defaultDisabled)
+	registerCollectorGroup("domain_stats", "iothread_info", defaultDisabled)
+}
+
 type DomainStatsCollector struct {
 	prometheus.Collector
 
-	logger     *slog.Logger
-	connection *libvirt.Connect
+	logger       *slog.Logger
+	connection   *Connection
+	scrape       *scrapeMetrics
+	info         bool
+	blockErrors  bool
+	ioThreadInfo bool
+
+	// statsTypes are the stats of the enabled groups, the metrics of which
+	// are exported.  libvirt is asked for the state on top of them, see
+	// getAllDomainStats.
+	statsTypes libvirt.DomainStatsTypes
+	filter     DomainFilter
 
-	Nova bool
+	// noWait asks libvirt not to wait on domains busy with a job, until
+	// libvirt turns out not to support it.
+	noWait            bool
+	noWaitUnsupported atomic.Bool
 
-	DomainSeconds *prometheus.Desc
+	// backing reports the block stats of every layer of the backing chain
+	// of disks, told apart by their backing index.
+	backing bool
 
+	// dirtyRateCalcInterval, if set, starts a dirty rate calculation of
+	// dirtyRateCalcPeriod on running domains on this interval.
+	dirtyRateCalcInterval time.Duration
+	dirtyRateCalcPeriod   time.Duration
+
+	// vmDescs caches the descriptions of the hypervisor statistics, which
+	// are only known once collected, keyed by metric name.
+	vmDescs sync.Map
+
+	Nova      bool
+	NameLabel bool
+
+	DomainInfo            *prometheus.Desc
+	DomainSeconds         *prometheus.Desc
+	DomainStatsIncomplete *prometheus.Desc
+
+	DomainState             *prometheus.Desc
+	DomainStateReason       *prometheus.Desc
 	DomainDomainState       *prometheus.Desc
 	DomainDomainStateReason *prometheus.Desc
 
-	DomainCPUTime   *prometheus.Desc
-	DomainCPUUser   *prometheus.Desc
-	DomainCPUSystem *prometheus.Desc
-
-	DomainBalloonCurrent        *prometheus.Desc
-	DomainBalloonMaximum        *prometheus.Desc
-	DomainBalloonSwapIn         *prometheus.Desc
-	DomainBalloonSwapOut        *prometheus.Desc
-	DomainBalloonMajorFault     *prometheus.Desc
-	DomainBalloonMinorFault     *prometheus.Desc
-	DomainBalloonUnused         *prometheus.Desc
-	DomainBalloonAvailable      *prometheus.Desc
-	DomainBalloonRss            *prometheus.Desc
-	DomainBalloonUsable         *prometheus.Desc
-	DomainBalloonDiskCaches     *prometheus.Desc
-	DomainBalloonHugetlbPgAlloc *prometheus.Desc
-	DomainBalloonHugetlbPgFail  *prometheus.Desc
-
-	DomainVcpuState *prometheus.Desc
-	DomainVcpuTime  *prometheus.Desc
-
-	DomainNetRxBytes *prometheus.Desc
-	DomainNetRxPkts  *prometheus.Desc
-	DomainNetRxErrs  *prometheus.Desc
-	DomainNetRxDrop  *prometheus.Desc
-	DomainNetTxBytes *prometheus.Desc
-	DomainNetTxPkts  *prometheus.Desc
-	DomainNetTxErrs  *prometheus.Desc
-	DomainNetTxDrop  *prometheus.Desc
-
-	DomainBlockRdReqs     *prometheus.Desc
-	DomainBlockRdBytes    *prometheus.Desc
-	DomainBlockRdTimes    *prometheus.Desc
-	DomainBlockWrReqs     *prometheus.Desc
-	DomainBlockWrBytes    *prometheus.Desc
-	DomainBlockWrTimes    *prometheus.Desc
-	DomainBlockFlReqs     *prometheus.Desc
-	DomainBlockFlTimes    *prometheus.Desc
+	DomainCPUTime   *namedDesc
+	DomainCPUUser   *namedDesc
+	DomainCPUSystem *namedDesc
+
+	DomainCPUCacheMonitor *prometheus.Desc
+
+	DomainMemoryBandwidthLocal *prometheus.Desc
+	DomainMemoryBandwidthTotal *prometheus.Desc
+
+	DomainDirtyRateCalcStatus    *prometheus.Desc
+	DomainDirtyRateCalcStartTime *prometheus.Desc
+	DomainDirtyRateCalcPeriod    *prometheus.Desc
+	DomainDirtyRate              *prometheus.Desc
+	DomainDirtyRateVcpu          *prometheus.Desc
+
+	DomainBalloonCurrent        *namedDesc
+	DomainBalloonMaximum        *namedDesc
+	DomainBalloonSwapIn         *namedDesc
+	DomainBalloonSwapOut        *namedDesc
+	DomainBalloonMajorFault     *namedDesc
+	DomainBalloonMinorFault     *namedDesc
+	DomainBalloonUnused         *namedDesc
+	DomainBalloonAvailable      *namedDesc
+	DomainBalloonRss            *namedDesc
+	DomainBalloonUsable         *namedDesc
+	DomainBalloonDiskCaches     *namedDesc
+	DomainBalloonHugetlbPgAlloc *namedDesc
+	DomainBalloonHugetlbPgFail  *namedDesc
+
+	DomainVcpuState  *prometheus.Desc
+	DomainVcpuTime   *namedDesc
+	DomainVcpuWait   *prometheus.Desc
+	DomainVcpuDelay  *prometheus.Desc
+	DomainVcpuHalted *prometheus.Desc
+	DomainCPUDelay   *prometheus.Desc
+
+	DomainNetInfo    *prometheus.Desc
+	DomainNetRxBytes *namedDesc
+	DomainNetRxPkts  *namedDesc
+	DomainNetRxErrs  *namedDesc
+	DomainNetRxDrop  *namedDesc
+	DomainNetTxBytes *namedDesc
+	DomainNetTxPkts  *namedDesc
+	DomainNetTxErrs  *namedDesc
+	DomainNetTxDrop  *namedDesc
+
+	DomainBlockInfo       *prometheus.Desc
+	DomainBlockRdReqs     *namedDesc
+	DomainBlockRdBytes    *namedDesc
+	DomainBlockRdTimes    *namedDesc
+	DomainBlockWrReqs     *namedDesc
+	DomainBlockWrBytes    *namedDesc
+	DomainBlockWrTimes    *namedDesc
+	DomainBlockFlReqs     *namedDesc
+	DomainBlockFlTimes    *namedDesc
 	DomainBlockErrors     *prometheus.Desc
-	DomainBlockAllocation *prometheus.Desc
-	DomainBlockCapacity   *prometheus.Desc
-	DomainBlockPhysical   *prometheus.Desc
+	DomainBlockThreshold  *prometheus.Desc
+	DomainBlockAllocation *namedDesc
+	DomainBlockCapacity   *namedDesc
+	DomainBlockPhysical   *namedDesc
+
+	// DomainPerf describes the events of domainPerfEvents.
+	DomainPerf []*prometheus.Desc
+
+	DomainIOThreadInfo       *prometheus.Desc
+	DomainIOThreadPollMax    *prometheus.Desc
+	DomainIOThreadPollGrow   *prometheus.Desc
+	DomainIOThreadPollShrink *prometheus.Desc
+}
+
+// domainLabels identify the domain a metric belongs to.
+type domainLabels struct {
+	uuid string
+	name string
 }
 
+// newDomainDesc describes a metric of a single domain, labelled by its UUID
+// and, if nameLabel is set, its name on top of the given labels.
+func newDomainDesc(nameLabel bool, name string, help string, labels ...string) *prometheus.Desc {
+	labels = append([]string{"uuid"}, labels...)
+	if nameLabel {
+		labels = append(labels, "name")
+	}
+
+	return prometheus.NewDesc(name, help, labels, nil)
+}
+
+// now is swapped out in tests so that the Nova domain age is predictable.
+var now = time.Now
+
 type NovaFlavorMetadata struct {
 	Name string `xml:"name,attr"`
 }
@@ -98,229 +222,442 @@ type NovaMetadata struct {
 }
 
 // nolint:funlen
-func NewDomainStatsCollector(logger *slog.Logger, connection *libvirt.Connect, nova bool) *DomainStatsCollector {
+func NewDomainStatsCollector(logger *slog.Logger, connection *Connection, opts *Options) *DomainStatsCollector {
+	var statsTypes libvirt.DomainStatsTypes
+	for _, group := range domainStatsGroups {
+		if opts.IsEnabled("domain_stats." + group.name) {
+			statsTypes |= group.statsTypes
+		}
+	}
+
+	// domainDesc describes a metric of a single domain, labelled by its
+	// UUID and optionally its name on top of the given labels.
+	domainDesc := func(name string, help string, labels ...string) *prometheus.Desc {
+		return newDomainDesc(opts.DomainNameLabel, name, help, labels...)
+	}
+
+	// namedDomainDesc describes a metric of a single domain under its
+	// legacy name and its conventional name, see namedDesc.
+	namedDomainDesc := func(
+		legacyName string, legacyHelp string, legacyType prometheus.ValueType,
+		name string, help string, valueType prometheus.ValueType,
+		scale float64, labels ...string,
+	) *namedDesc {
+		return &namedDesc{
+			scheme:           opts.NamingScheme,
+			legacy:           domainDesc(legacyName, legacyHelp, labels...),
+			legacyType:       legacyType,
+			conventional:     domainDesc(name, help, labels...),
+			conventionalType: valueType,
+			scale:            scale,
+		}
+	}
+
+	// NOTE: Every layer of the backing chain of a disk shares its target
+	//       device, the backing index tells them apart.
+	blockLabels := []string{"device", "path"}
+	if opts.DomainBlockBacking {
+		blockLabels = append(blockLabels, "backing_index")
+	}
+
+	domainPerf := make([]*prometheus.Desc, 0, len(domainPerfEvents))
+	for _, event := range domainPerfEvents {
+		domainPerf = append(domainPerf, domainDesc(event.name, event.help))
+	}
+
 	return &DomainStatsCollector{
-		logger:     logger,
-		connection: connection,
-		Nova:       nova,
+		logger:       logger,
+		connection:   connection,
+		scrape:       newScrapeMetrics("domain_stats"),
+		statsTypes:   statsTypes,
+		info:         opts.IsEnabled("domain_stats.info"),
+		blockErrors:  opts.IsEnabled("domain_stats.block_errors"),
+		ioThreadInfo: opts.IsEnabled("domain_stats.iothread_info"),
+		filter:       opts.DomainFilter,
+		noWait:       opts.DomainStatsNoWait,
+		backing:      opts.DomainBlockBacking,
 
-		DomainSeconds: prometheus.NewDesc(
+		dirtyRateCalcInterval: opts.DirtyRateCalcInterval,
+		dirtyRateCalcPeriod:   opts.DirtyRateCalcPeriod,
+
+		Nova:      opts.Nova,
+		NameLabel: opts.DomainNameLabel,
+
+		DomainInfo: prometheus.NewDesc(
+			"libvirtd_domain_info",
+			"information about the domain",
+			[]string{"uuid", "name", "title", "os_type", "machine_type", "hypervisor_type"}, nil,
+		),
+		DomainSeconds: domainDesc(
 			"libvirtd_domain_seconds",
 			"seconds since creation time",
-			[]string{"uuid", "instance_type", "user_id", "project_id"}, nil,
+			"instance_type", "user_id", "project_id",
+		),
+		DomainStatsIncomplete: domainDesc(
+			"libvirtd_domain_stats_incomplete",
+			"whether the stats of the domain are incomplete because it was busy with a job",
 		),
 
-		DomainDomainState: prometheus.NewDesc(
+		DomainState: domainDesc(
+			"libvirtd_domain_state",
+			"whether the domain is in the given state",
+			"state",
+		),
+		DomainStateReason: domainDesc(
+			"libvirtd_domain_state_reason_info",
+			"reason for entering the current state of the domain",
+			"state", "reason",
+		),
+		DomainDomainState: domainDesc(
 			"libvirtd_domain_domain_state",
 			"state of the VM (virDomainState enum)",
-			[]string{"uuid"}, nil,
 		),
-		DomainDomainStateReason: prometheus.NewDesc(
+		DomainDomainStateReason: domainDesc(
 			"libvirtd_domain_domain_state_reason",
 			"reason for entering given state (virDomain*Reason enum)",
-			[]string{"uuid"}, nil,
-		),
-
-		DomainCPUTime: prometheus.NewDesc(
-			"libvirtd_domain_cpu_time",
-			"total cpu time spent for this domain in nanoseconds",
-			[]string{"uuid"}, nil,
-		),
-		DomainCPUUser: prometheus.NewDesc(
-			"libvirtd_domain_cpu_user",
-			"user cpu time spent in nanoseconds",
-			[]string{"uuid"}, nil,
-		),
-		DomainCPUSystem: prometheus.NewDesc(
-			"libvirtd_domain_cpu_system",
-			"system cpu time spent in nanoseconds",
-			[]string{"uuid"}, nil,
-		),
-
-		DomainBalloonCurrent: prometheus.NewDesc(
-			"libvirtd_domain_balloon_current",
-			"the memory in kiB currently used",
-			[]string{"uuid"}, nil,
-		),
-		DomainBalloonMaximum: prometheus.NewDesc(
-			"libvirtd_domain_balloon_maximum",
-			"the maximum memory in kiB allowed",
-			[]string{"uuid"}, nil,
-		),
-		DomainBalloonSwapIn: prometheus.NewDesc(
-			"libvirtd_domain_balloon_swap_in",
-			"kiB of memory swapped in",
-			[]string{"uuid"}, nil,
-		),
-		DomainBalloonSwapOut: prometheus.NewDesc(
-			"libvirtd_domain_balloon_swap_out",
-			"kiB of memory swapped out",
-			[]string{"uuid"}, nil,
-		),
-		DomainBalloonMajorFault: prometheus.NewDesc(
-			"libvirtd_domain_balloon_major_fault",
-			"number of page faults where disk I/O was required",
-			[]string{"uuid"}, nil,
-		),
-		DomainBalloonMinorFault: prometheus.NewDesc(
-			"libvirtd_domain_balloon_minor_fault",
-			"number of page faults where disk I/O was not required",
-			[]string{"uuid"}, nil,
-		),
-		DomainBalloonUnused: prometheus.NewDesc(
-			"libvirtd_domain_balloon_unused",
-			"KiB of memory left unused by the system",
-			[]string{"uuid"}, nil,
-		),
-		DomainBalloonAvailable: prometheus.NewDesc(
-			"libvirtd_domain_balloon_available",
-			"KiB of memory usable by the domain",
-			[]string{"uuid"}, nil,
-		),
-		DomainBalloonRss: prometheus.NewDesc(
-			"libvirtd_domain_balloon_rss",
-			"resident set size of domain in KiB",
-			[]string{"uuid"}, nil,
-		),
-		DomainBalloonUsable: prometheus.NewDesc(
-			"libvirtd_domain_balloon_usable",
-			"KiB of memory that can be reclaimed without swapping",
-			[]string{"uuid"}, nil,
-		),
-		DomainBalloonDiskCaches: prometheus.NewDesc(
-			"libvirtd_domain_balloon_disk_caches",
-			"KiB of memory used by disk caches",
-			[]string{"uuid"}, nil,
-		),
-		DomainBalloonHugetlbPgAlloc: prometheus.NewDesc(
-			"libvirtd_domain_balloon_hugetlb_pgalloc",
-			"number of successful huge page allocations done by virtio balloon",
-			[]string{"uuid"}, nil,
-		),
-		DomainBalloonHugetlbPgFail: prometheus.NewDesc(
-			"libvirtd_domain_balloon_hugetlb_pgfail",
-			"number of failed huge page allocations done by virtio balloon",
-			[]string{"uuid"}, nil,
-		),
-
-		DomainVcpuState: prometheus.NewDesc(
+		),
+
+		DomainCPUTime: namedDomainDesc(
+			"libvirtd_domain_cpu_time", "total cpu time spent for this domain in nanoseconds", prometheus.CounterValue,
+			"libvirtd_domain_cpu_seconds_total", "total cpu time spent for this domain in seconds", prometheus.CounterValue,
+			nanoseconds,
+		),
+		DomainCPUUser: namedDomainDesc(
+			"libvirtd_domain_cpu_user", "user cpu time spent in nanoseconds", prometheus.CounterValue,
+			"libvirtd_domain_cpu_user_seconds_total", "user cpu time spent in seconds", prometheus.CounterValue,
+			nanoseconds,
+		),
+		DomainCPUSystem: namedDomainDesc(
+			"libvirtd_domain_cpu_system", "system cpu time spent in nanoseconds", prometheus.CounterValue,
+			"libvirtd_domain_cpu_system_seconds_total", "system cpu time spent in seconds", prometheus.CounterValue,
+			nanoseconds,
+		),
+
+		DomainCPUCacheMonitor: domainDesc(
+			"libvirtd_domain_cpu_cache_monitor_bytes",
+			"last level cache occupancy of the vcpus of the cache monitor in bytes",
+			"monitor", "vcpus", "bank",
+		),
+
+		DomainMemoryBandwidthLocal: domainDesc(
+			"libvirtd_domain_memory_bandwidth_local_bytes_total",
+			"bytes of memory bandwidth used by the vcpus of the monitor on the memory controller of their host cpu",
+			"monitor", "vcpus", "node",
+		),
+		DomainMemoryBandwidthTotal: domainDesc(
+			"libvirtd_domain_memory_bandwidth_bytes_total",
+			"bytes of memory bandwidth used by the vcpus of the monitor on all memory controllers of the node",
+			"monitor", "vcpus", "node",
+		),
+
+		DomainDirtyRateCalcStatus: domainDesc(
+			"libvirtd_domain_dirtyrate_calc_status",
+			"whether the last dirty rate calculation of the domain is in the given status",
+			"status",
+		),
+		DomainDirtyRateCalcStartTime: domainDesc(
+			"libvirtd_domain_dirtyrate_calc_start_time_seconds",
+			"start time of the last dirty rate calculation of the domain on the monotonic clock of the hypervisor",
+		),
+		DomainDirtyRateCalcPeriod: domainDesc(
+			"libvirtd_domain_dirtyrate_calc_period_seconds",
+			"period of the last dirty rate calculation of the domain in seconds",
+		),
+		DomainDirtyRate: domainDesc(
+			"libvirtd_domain_dirtyrate_bytes_per_second",
+			"rate the domain dirtied memory at during the last calculation in bytes per second",
+		),
+		DomainDirtyRateVcpu: domainDesc(
+			"libvirtd_domain_dirtyrate_vcpu_bytes_per_second",
+			"rate the vcpu dirtied memory at during the last calculation in bytes per second",
+			"vcpu",
+		),
+
+		DomainBalloonCurrent: namedDomainDesc(
+			"libvirtd_domain_balloon_current", "the memory in kiB currently used", prometheus.GaugeValue,
+			"libvirtd_domain_balloon_current_bytes", "the memory in bytes currently used", prometheus.GaugeValue,
+			kibibytes,
+		),
+		DomainBalloonMaximum: namedDomainDesc(
+			"libvirtd_domain_balloon_maximum", "the maximum memory in kiB allowed", prometheus.GaugeValue,
+			"libvirtd_domain_balloon_maximum_bytes", "the maximum memory in bytes allowed", prometheus.GaugeValue,
+			kibibytes,
+		),
+		DomainBalloonSwapIn: namedDomainDesc(
+			"libvirtd_domain_balloon_swap_in", "kiB of memory swapped in", prometheus.CounterValue,
+			"libvirtd_domain_balloon_swap_in_bytes_total", "bytes of memory swapped in", prometheus.CounterValue,
+			kibibytes,
+		),
+		DomainBalloonSwapOut: namedDomainDesc(
+			"libvirtd_domain_balloon_swap_out", "kiB of memory swapped out", prometheus.CounterValue,
+			"libvirtd_domain_balloon_swap_out_bytes_total", "bytes of memory swapped out", prometheus.CounterValue,
+			kibibytes,
+		),
+		DomainBalloonMajorFault: namedDomainDesc(
+			"libvirtd_domain_balloon_major_fault", "number of page faults where disk I/O was required", prometheus.CounterValue,
+			"libvirtd_domain_balloon_major_faults_total", "number of page faults where disk I/O was required", prometheus.CounterValue,
+			1,
+		),
+		DomainBalloonMinorFault: namedDomainDesc(
+			"libvirtd_domain_balloon_minor_fault", "number of page faults where disk I/O was not required", prometheus.CounterValue,
+			"libvirtd_domain_balloon_minor_faults_total", "number of page faults where disk I/O was not required", prometheus.CounterValue,
+			1,
+		),
+		DomainBalloonUnused: namedDomainDesc(
+			"libvirtd_domain_balloon_unused", "KiB of memory left unused by the system", prometheus.GaugeValue,
+			"libvirtd_domain_balloon_unused_bytes", "bytes of memory left unused by the system", prometheus.GaugeValue,
+			kibibytes,
+		),
+		DomainBalloonAvailable: namedDomainDesc(
+			"libvirtd_domain_balloon_available", "KiB of memory usable by the domain", prometheus.GaugeValue,
+			"libvirtd_domain_balloon_available_bytes", "bytes of memory usable by the domain", prometheus.GaugeValue,
+			kibibytes,
+		),
+		DomainBalloonRss: namedDomainDesc(
+			"libvirtd_domain_balloon_rss", "resident set size of domain in KiB", prometheus.GaugeValue,
+			"libvirtd_domain_balloon_rss_bytes", "resident set size of domain in bytes", prometheus.GaugeValue,
+			kibibytes,
+		),
+		DomainBalloonUsable: namedDomainDesc(
+			"libvirtd_domain_balloon_usable", "KiB of memory that can be reclaimed without swapping", prometheus.GaugeValue,
+			"libvirtd_domain_balloon_usable_bytes", "bytes of memory that can be reclaimed without swapping", prometheus.GaugeValue,
+			kibibytes,
+		),
+		DomainBalloonDiskCaches: namedDomainDesc(
+			"libvirtd_domain_balloon_disk_caches", "KiB of memory used by disk caches", prometheus.GaugeValue,
+			"libvirtd_domain_balloon_disk_caches_bytes", "bytes of memory used by disk caches", prometheus.GaugeValue,
+			kibibytes,
+		),
+		DomainBalloonHugetlbPgAlloc: namedDomainDesc(
+			"libvirtd_domain_balloon_hugetlb_pgalloc", "number of successful huge page allocations done by virtio balloon", prometheus.CounterValue,
+			"libvirtd_domain_balloon_hugetlb_pgalloc_total", "number of successful huge page allocations done by virtio balloon", prometheus.CounterValue,
+			1,
+		),
+		DomainBalloonHugetlbPgFail: namedDomainDesc(
+			"libvirtd_domain_balloon_hugetlb_pgfail", "number of failed huge page allocations done by virtio balloon", prometheus.CounterValue,
+			"libvirtd_domain_balloon_hugetlb_pgfail_total", "number of failed huge page allocations done by virtio balloon", prometheus.CounterValue,
+			1,
+		),
+
+		DomainVcpuState: domainDesc(
 			"libvirtd_domain_vcpu_state",
 			"state of the virtual CPU (virVcpuState enum)",
-			[]string{"uuid", "vcpu"}, nil,
-		),
-		DomainVcpuTime: prometheus.NewDesc(
-			"libvirtd_domain_vcpu_time",
-			"virtual cpu time spent",
-			[]string{"uuid", "vcpu"}, nil,
+			"vcpu",
+		),
+		DomainVcpuTime: namedDomainDesc(
+			"libvirtd_domain_vcpu_time", "virtual cpu time spent", prometheus.CounterValue,
+			"libvirtd_domain_vcpu_seconds_total", "virtual cpu time spent in seconds", prometheus.CounterValue,
+			nanoseconds, "vcpu",
+		),
+		DomainVcpuWait: domainDesc(
+			"libvirtd_domain_vcpu_wait_seconds_total",
+			"virtual cpu time spent waiting on I/O in seconds",
+			"vcpu",
+		),
+		DomainVcpuDelay: domainDesc(
+			"libvirtd_domain_vcpu_delay_seconds_total",
+			"virtual cpu time spent runnable but waiting to run on a host cpu in seconds",
+			"vcpu",
+		),
+		DomainVcpuHalted: domainDesc(
+			"libvirtd_domain_vcpu_halted",
+			"whether the virtual CPU is halted",
+			"vcpu",
+		),
+		DomainCPUDelay: domainDesc(
+			"libvirtd_domain_cpu_delay_seconds_total",
+			"total time the virtual cpus of this domain spent runnable but waiting to run on a host cpu in seconds",
 		),
 
-		DomainNetRxBytes: prometheus.NewDesc(
-			"libvirtd_domain_net_rx_bytes",
-			"bytes received",
-			[]string{"uuid", "interface"}, nil,
+		DomainNetInfo: domainDesc(
+			"libvirtd_domain_interface_info",
+			"information about the network interface from the domain definition",
+			"interface", "mac", "source_bridge", "source_network", "source_portgroup", "model", "vlan", "interface_id",
 		),
-		DomainNetRxPkts: prometheus.NewDesc(
-			"libvirtd_domain_net_rx_packets",
-			"packets received",
-			[]string{"uuid", "interface"}, nil,
+		DomainNetRxBytes: namedDomainDesc(
+			"libvirtd_domain_net_rx_bytes", "bytes received", prometheus.CounterValue,
+			"libvirtd_domain_net_rx_bytes_total", "bytes received", prometheus.CounterValue,
+			1, "interface",
 		),
-		DomainNetRxErrs: prometheus.NewDesc(
-			"libvirtd_domain_net_rx_errors",
-			"receive errors",
-			[]string{"uuid", "interface"}, nil,
+		DomainNetRxPkts: namedDomainDesc(
+			"libvirtd_domain_net_rx_packets", "packets received", prometheus.CounterValue,
+			"libvirtd_domain_net_rx_packets_total", "packets received", prometheus.CounterValue,
+			1, "interface",
 		),
-		DomainNetRxDrop: prometheus.NewDesc(
-			"libvirtd_domain_net_rx_drop",
-			"receive packets dropped",
-			[]string{"uuid", "interface"}, nil,
+		DomainNetRxErrs: namedDomainDesc(
+			"libvirtd_domain_net_rx_errors", "receive errors", prometheus.CounterValue,
+			"libvirtd_domain_net_rx_errors_total", "receive errors", prometheus.CounterValue,
+			1, "interface",
 		),
-		DomainNetTxBytes: prometheus.NewDesc(
-			"libvirtd_domain_net_tx_bytes",
-			"bytes transmitted",
-			[]string{"uuid", "interface"}, nil,
+		DomainNetRxDrop: namedDomainDesc(
+			"libvirtd_domain_net_rx_drop", "receive packets dropped", prometheus.CounterValue,
+			"libvirtd_domain_net_rx_drops_total", "receive packets dropped", prometheus.CounterValue,
+			1, "interface",
 		),
-		DomainNetTxPkts: prometheus.NewDesc(
-			"libvirtd_domain_net_tx_packets",
-			"packets transmitted",
-			[]string{"uuid", "interface"}, nil,
+		DomainNetTxBytes: namedDomainDesc(
+			"libvirtd_domain_net_tx_bytes", "bytes transmitted", prometheus.CounterValue,
+			"libvirtd_domain_net_tx_bytes_total", "bytes transmitted", prometheus.CounterValue,
+			1, "interface",
 		),
-		DomainNetTxErrs: prometheus.NewDesc(
-			"libvirtd_domain_net_tx_errors",
-			"transmission errors",
-			[]string{"uuid", "interface"}, nil,
+		DomainNetTxPkts: namedDomainDesc(
+			"libvirtd_domain_net_tx_packets", "packets transmitted", prometheus.CounterValue,
+			"libvirtd_domain_net_tx_packets_total", "packets transmitted", prometheus.CounterValue,
+			1, "interface",
 		),
-		DomainNetTxDrop: prometheus.NewDesc(
-			"libvirtd_domain_net_tx_drop",
-			"transmit packets dropped",
-			[]string{"uuid", "interface"}, nil,
+		DomainNetTxErrs: namedDomainDesc(
+			"libvirtd_domain_net_tx_errors", "transmission errors", prometheus.CounterValue,
+			"libvirtd_domain_net_tx_errors_total", "transmission errors", prometheus.CounterValue,
+			1, "interface",
+		),
+		DomainNetTxDrop: namedDomainDesc(
+			"libvirtd_domain_net_tx_drop", "transmit packets dropped", prometheus.GaugeValue,
+			"libvirtd_domain_net_tx_drops_total", "transmit packets dropped", prometheus.CounterValue,
+			1, "interface",
 		),
 
-		DomainBlockRdReqs: prometheus.NewDesc(
-			"libvirtd_domain_block_read_requests",
-			"number of read requests",
-			[]string{"uuid", "device", "path"}, nil,
+		DomainBlockInfo: domainDesc(
+			"libvirtd_domain_block_info",
+			"information about the block device from the domain definition",
+			"device", "bus", "format", "cache", "io", "discard", "serial", "iothread",
+		),
+		DomainBlockErrors: domainDesc(
+			"libvirtd_domain_block_errors",
+			"whether the block device hit the given I/O error, such as running out of space",
+			"device", "error",
+		),
+		DomainBlockThreshold: domainDesc(
+			"libvirtd_domain_block_threshold_bytes",
+			"write threshold of the block device in bytes, an event is raised once its allocation crosses it",
+			blockLabels...,
 		),
-		DomainBlockRdBytes: prometheus.NewDesc(
-			"libvirtd_domain_block_read_bytes",
-			"number of read bytes",
-			[]string{"uuid", "device", "path"}, nil,
+		DomainBlockRdReqs: namedDomainDesc(
+			"libvirtd_domain_block_read_requests", "number of read requests", prometheus.CounterValue,
+			"libvirtd_domain_block_read_requests_total", "number of read requests", prometheus.CounterValue,
+			1, blockLabels...,
 		),
-		DomainBlockRdTimes: prometheus.NewDesc(
-			"libvirtd_domain_block_read_times",
-			"total time (ns) spent on reads",
-			[]string{"uuid", "device", "path"}, nil,
+		DomainBlockRdBytes: namedDomainDesc(
+			"libvirtd_domain_block_read_bytes", "number of read bytes", prometheus.CounterValue,
+			"libvirtd_domain_block_read_bytes_total", "number of read bytes", prometheus.CounterValue,
+			1, blockLabels...,
 		),
-		DomainBlockWrReqs: prometheus.NewDesc(
-			"libvirtd_domain_block_write_requests",
-			"number of written requests",
-			[]string{"uuid", "device", "path"}, nil,
+		DomainBlockRdTimes: namedDomainDesc(
+			"libvirtd_domain_block_read_times", "total time (ns) spent on reads", prometheus.CounterValue,
+			"libvirtd_domain_block_read_seconds_total", "total time spent on reads in seconds", prometheus.CounterValue,
+			nanoseconds, blockLabels...,
 		),
-		DomainBlockWrBytes: prometheus.NewDesc(
-			"libvirtd_domain_block_write_bytes",
-			"number of written bytes",
-			[]string{"uuid", "device", "path"}, nil,
+		DomainBlockWrReqs: namedDomainDesc(
+			"libvirtd_domain_block_write_requests", "number of written requests", prometheus.CounterValue,
+			"libvirtd_domain_block_write_requests_total", "number of written requests", prometheus.CounterValue,
+			1, blockLabels...,
 		),
-		DomainBlockWrTimes: prometheus.NewDesc(
-			"libvirtd_domain_block_write_times",
-			"total time (ns) spent on writes",
-			[]string{"uuid", "device", "path"}, nil,
+		DomainBlockWrBytes: namedDomainDesc(
+			"libvirtd_domain_block_write_bytes", "number of written bytes", prometheus.CounterValue,
+			"libvirtd_domain_block_write_bytes_total", "number of written bytes", prometheus.CounterValue,
+			1, blockLabels...,
 		),
-		DomainBlockFlReqs: prometheus.NewDesc(
-			"libvirtd_domain_block_flush_requests",
-			"total flush requests",
-			[]string{"uuid", "device", "path"}, nil,
+		DomainBlockWrTimes: namedDomainDesc(
+			"libvirtd_domain_block_write_times", "total time (ns) spent on writes", prometheus.CounterValue,
+			"libvirtd_domain_block_write_seconds_total", "total time spent on writes in seconds", prometheus.CounterValue,
+			nanoseconds, blockLabels...,
 		),
-		DomainBlockFlTimes: prometheus.NewDesc(
-			"libvirtd_domain_block_flush_times",
-			"total time (ns) spent on cache flushing",
-			[]string{"uuid", "device", "path"}, nil,
+		DomainBlockFlReqs: namedDomainDesc(
+			"libvirtd_domain_block_flush_requests", "total flush requests", prometheus.CounterValue,
+			"libvirtd_domain_block_flush_requests_total", "total flush requests", prometheus.CounterValue,
+			1, blockLabels...,
 		),
-		DomainBlockAllocation: prometheus.NewDesc(
-			"libvirtd_domain_block_allocation",
-			"offset of the highest written sector",
-			[]string{"uuid", "device", "path"}, nil,
+		DomainBlockFlTimes: namedDomainDesc(
+			"libvirtd_domain_block_flush_times", "total time (ns) spent on cache flushing", prometheus.CounterValue,
+			"libvirtd_domain_block_flush_seconds_total", "total time spent on cache flushing in seconds", prometheus.CounterValue,
+			nanoseconds, blockLabels...,
 		),
-		DomainBlockCapacity: prometheus.NewDesc(
-			"libvirtd_domain_block_capacity",
-			"logical size in bytes of the block device backing image",
-			[]string{"uuid", "device", "path"}, nil,
+		DomainBlockAllocation: namedDomainDesc(
+			"libvirtd_domain_block_allocation", "offset of the highest written sector", prometheus.GaugeValue,
+			"libvirtd_domain_block_allocation_bytes", "offset of the highest written sector in bytes", prometheus.GaugeValue,
+			1, blockLabels...,
 		),
-		DomainBlockPhysical: prometheus.NewDesc(
-			"libvirtd_domain_block_physical",
-			"physical size in bytes of the container of the backing image",
-			[]string{"uuid", "device", "path"}, nil,
+		DomainBlockCapacity: namedDomainDesc(
+			"libvirtd_domain_block_capacity", "logical size in bytes of the block device backing image", prometheus.GaugeValue,
+			"libvirtd_domain_block_capacity_bytes", "logical size in bytes of the block device backing image", prometheus.GaugeValue,
+			1, blockLabels...,
+		),
+		DomainBlockPhysical: namedDomainDesc(
+			"libvirtd_domain_block_physical", "physical size in bytes of the container of the backing image", prometheus.GaugeValue,
+			"libvirtd_domain_block_physical_bytes", "physical size in bytes of the container of the backing image", prometheus.GaugeValue,
+			1, blockLabels...,
+		),
+
+		DomainPerf: domainPerf,
+
+		DomainIOThreadInfo: domainDesc(
+			"libvirtd_domain_iothread_info",
+			"information about the iothread, with the host cpus it is pinned to",
+			"iothread", "cpus",
+		),
+		DomainIOThreadPollMax: domainDesc(
+			"libvirtd_domain_iothread_poll_max_seconds",
+			"maximum polling time of the iothread in seconds, 0 if polling is disabled",
+			"iothread",
+		),
+		DomainIOThreadPollGrow: domainDesc(
+			"libvirtd_domain_iothread_poll_grow",
+			"factor the polling time of the iothread grows by, 0 for the hypervisor default",
+			"iothread",
+		),
+		DomainIOThreadPollShrink: domainDesc(
+			"libvirtd_domain_iothread_poll_shrink",
+			"divisor the polling time of the iothread shrinks by, 0 for the hypervisor default",
+			"iothread",
 		),
 	}
 }
 
 func (c *DomainStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.scrape.Describe(ch)
 	c.describeNova(ch)
-	c.describeState(ch)
-	c.describeCPU(ch)
-	c.describeBalloon(ch)
-	c.describeVcpu(ch)
-	c.describeNet(ch)
-	c.describeBlock(ch)
+
+	if c.info {
+		c.describeInfo(ch)
+	}
+	if c.noWait {
+		ch <- c.DomainStatsIncomplete
+	}
+
+	if c.statsTypes&libvirt.DOMAIN_STATS_STATE != 0 {
+		c.describeState(ch)
+	}
+	if c.statsTypes&libvirt.DOMAIN_STATS_CPU_TOTAL != 0 {
+		c.describeCPU(ch)
+	}
+	if c.statsTypes&libvirt.DOMAIN_STATS_BALLOON != 0 {
+		c.describeBalloon(ch)
+	}
+	if c.statsTypes&libvirt.DOMAIN_STATS_VCPU != 0 {
+		c.describeVcpu(ch)
+	}
+	if c.statsTypes&libvirt.DOMAIN_STATS_INTERFACE != 0 {
+		c.describeNet(ch)
+	}
+	if c.statsTypes&libvirt.DOMAIN_STATS_BLOCK != 0 {
+		c.describeBlock(ch)
+	}
+	if c.blockErrors {
+		ch <- c.DomainBlockErrors
+	}
+	if c.ioThreadInfo {
+		ch <- c.DomainIOThreadInfo
+	}
+	if c.statsTypes&libvirt.DOMAIN_STATS_PERF != 0 {
+		c.describePerf(ch)
+	}
+	if c.statsTypes&libvirt.DOMAIN_STATS_IOTHREAD != 0 {
+		c.describeIOThread(ch)
+	}
+	if c.statsTypes&libvirt.DOMAIN_STATS_MEMORY != 0 {
+		c.describeMemory(ch)
+	}
+	if c.statsTypes&libvirt.DOMAIN_STATS_DIRTYRATE != 0 {
+		c.describeDirtyRate(ch)
+	}
 }
 
 func (c *DomainStatsCollector) describeNova(ch chan<- *prometheus.Desc) {
@@ -329,394 +666,1095 @@ func (c *DomainStatsCollector) describeNova(ch chan<- *prometheus.Desc) {
 	}
 }
 
+func (c *DomainStatsCollector) describeInfo(ch chan<- *prometheus.Desc) {
+	ch <- c.DomainInfo
+}
+
 func (c *DomainStatsCollector) describeState(ch chan<- *prometheus.Desc) {
+	ch <- c.DomainState
+	ch <- c.DomainStateReason
 	ch <- c.DomainDomainState
 	ch <- c.DomainDomainStateReason
 }
 
 func (c *DomainStatsCollector) describeCPU(ch chan<- *prometheus.Desc) {
-	ch <- c.DomainCPUTime
-	ch <- c.DomainCPUUser
-	ch <- c.DomainCPUSystem
+	c.DomainCPUTime.Describe(ch)
+	c.DomainCPUUser.Describe(ch)
+	c.DomainCPUSystem.Describe(ch)
+	ch <- c.DomainCPUCacheMonitor
 }
 
 func (c *DomainStatsCollector) describeBalloon(ch chan<- *prometheus.Desc) {
-	ch <- c.DomainBalloonCurrent
-	ch <- c.DomainBalloonMaximum
-	ch <- c.DomainBalloonSwapIn
-	ch <- c.DomainBalloonSwapOut
-	ch <- c.DomainBalloonMajorFault
-	ch <- c.DomainBalloonMinorFault
-	ch <- c.DomainBalloonUnused
-	ch <- c.DomainBalloonAvailable
-	ch <- c.DomainBalloonRss
-	ch <- c.DomainBalloonUsable
-	ch <- c.DomainBalloonDiskCaches
-	ch <- c.DomainBalloonHugetlbPgAlloc
-	ch <- c.DomainBalloonHugetlbPgFail
+	c.DomainBalloonCurrent.Describe(ch)
+	c.DomainBalloonMaximum.Describe(ch)
+	c.DomainBalloonSwapIn.Describe(ch)
+	c.DomainBalloonSwapOut.Describe(ch)
+	c.DomainBalloonMajorFault.Describe(ch)
+	c.DomainBalloonMinorFault.Describe(ch)
+	c.DomainBalloonUnused.Describe(ch)
+	c.DomainBalloonAvailable.Describe(ch)
+	c.DomainBalloonRss.Describe(ch)
+	c.DomainBalloonUsable.Describe(ch)
+	c.DomainBalloonDiskCaches.Describe(ch)
+	c.DomainBalloonHugetlbPgAlloc.Describe(ch)
+	c.DomainBalloonHugetlbPgFail.Describe(ch)
 }
 
 func (c *DomainStatsCollector) describeVcpu(ch chan<- *prometheus.Desc) {
 	ch <- c.DomainVcpuState
-	ch <- c.DomainVcpuTime
+	c.DomainVcpuTime.Describe(ch)
+	ch <- c.DomainVcpuWait
+	ch <- c.DomainVcpuDelay
+	ch <- c.DomainVcpuHalted
+	ch <- c.DomainCPUDelay
 }
 
 func (c *DomainStatsCollector) describeNet(ch chan<- *prometheus.Desc) {
-	ch <- c.DomainNetRxBytes
-	ch <- c.DomainNetRxPkts
-	ch <- c.DomainNetRxErrs
-	ch <- c.DomainNetRxDrop
-	ch <- c.DomainNetTxBytes
-	ch <- c.DomainNetTxPkts
-	ch <- c.DomainNetTxErrs
-	ch <- c.DomainNetTxDrop
+	ch <- c.DomainNetInfo
+	c.DomainNetRxBytes.Describe(ch)
+	c.DomainNetRxPkts.Describe(ch)
+	c.DomainNetRxErrs.Describe(ch)
+	c.DomainNetRxDrop.Describe(ch)
+	c.DomainNetTxBytes.Describe(ch)
+	c.DomainNetTxPkts.Describe(ch)
+	c.DomainNetTxErrs.Describe(ch)
+	c.DomainNetTxDrop.Describe(ch)
 }
 
 func (c *DomainStatsCollector) describeBlock(ch chan<- *prometheus.Desc) {
-	ch <- c.DomainBlockRdReqs
-	ch <- c.DomainBlockRdBytes
-	ch <- c.DomainBlockRdTimes
-	ch <- c.DomainBlockWrReqs
-	ch <- c.DomainBlockWrBytes
-	ch <- c.DomainBlockWrTimes
-	ch <- c.DomainBlockFlReqs
-	ch <- c.DomainBlockFlTimes
-	ch <- c.DomainBlockAllocation
-	ch <- c.DomainBlockCapacity
-	ch <- c.DomainBlockPhysical
+	ch <- c.DomainBlockInfo
+	ch <- c.DomainBlockThreshold
+	c.DomainBlockRdReqs.Describe(ch)
+	c.DomainBlockRdBytes.Describe(ch)
+	c.DomainBlockRdTimes.Describe(ch)
+	c.DomainBlockWrReqs.Describe(ch)
+	c.DomainBlockWrBytes.Describe(ch)
+	c.DomainBlockWrTimes.Describe(ch)
+	c.DomainBlockFlReqs.Describe(ch)
+	c.DomainBlockFlTimes.Describe(ch)
+	c.DomainBlockAllocation.Describe(ch)
+	c.DomainBlockCapacity.Describe(ch)
+	c.DomainBlockPhysical.Describe(ch)
 }
 
-func (c *DomainStatsCollector) Collect(ch chan<- prometheus.Metric) {
-	alive, err := c.connection.IsAlive()
-	if err != nil {
-		c.logger.Error("Failed to check if connection is alive", "err", err)
-		return
+func (c *DomainStatsCollector) describePerf(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.DomainPerf {
+		ch <- desc
 	}
+}
 
-	if !alive {
-		uri, err := c.connection.GetURI()
-		if err != nil {
-			// NOTE(mnaser): If we get to this point, we don't have
-			//               a URI and we can't reconnect, die
-			c.logger.Error("Failed to get URI", "err", err)
-			return
-		}
+func (c *DomainStatsCollector) describeMemory(ch chan<- *prometheus.Desc) {
+	ch <- c.DomainMemoryBandwidthLocal
+	ch <- c.DomainMemoryBandwidthTotal
+}
 
-		_, err = c.connection.Close()
-		if err != nil {
-			c.logger.Error("Failed to close connection", "err", err)
-		}
+func (c *DomainStatsCollector) describeDirtyRate(ch chan<- *prometheus.Desc) {
+	ch <- c.DomainDirtyRateCalcStatus
+	ch <- c.DomainDirtyRateCalcStartTime
+	ch <- c.DomainDirtyRateCalcPeriod
+	ch <- c.DomainDirtyRate
+	ch <- c.DomainDirtyRateVcpu
+}
 
-		conn, err := libvirt.NewConnect(uri)
-		if err != nil {
-			c.logger.Error("Failed to reconnect", "err", err)
-			return
+func (c *DomainStatsCollector) describeIOThread(ch chan<- *prometheus.Desc) {
+	ch <- c.DomainIOThreadPollMax
+	ch <- c.DomainIOThreadPollGrow
+	ch <- c.DomainIOThreadPollShrink
+}
+
+func (c *DomainStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectWithContext(context.Background(), ch)
+}
+
+func (c *DomainStatsCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	c.scrape.collect(ctx, c.logger, c.connection, ch, c.collect)
+}
+
+func (c *DomainStatsCollector) collect(ctx context.Context, conn Connect, ch chan<- prometheus.Metric) error {
+	domains, err := c.listDomains(conn)
+	defer func() {
+		for _, domain := range domains {
+			c.freeDomain(domain)
 		}
-		c.connection = conn
+	}()
+
+	if err != nil {
+		return fmt.Errorf("failed to list domains: %w", err)
 	}
 
-	stats, err := c.connection.GetAllDomainStats(
-		[]*libvirt.Domain{},
-		libvirt.DOMAIN_STATS_STATE|libvirt.DOMAIN_STATS_CPU_TOTAL|libvirt.DOMAIN_STATS_BALLOON|
-			libvirt.DOMAIN_STATS_VCPU|libvirt.DOMAIN_STATS_INTERFACE|libvirt.DOMAIN_STATS_BLOCK,
-		0,
-	)
+	// NOTE: No domains at all would get the stats of every domain.
+	if !c.filter.IsEmpty() && len(domains) == 0 {
+		return nil
+	}
+
+	stats, err := c.getAllDomainStats(conn, domains)
 
-	defer func(stats []libvirt.DomainStats) {
+	defer func(stats []DomainStats) {
 		for _, stat := range stats {
-			err := stat.Domain.Free()
-			if err != nil {
-				c.logger.Error("Failed to free domain", "err", err)
-			}
+			c.freeDomain(stat.Domain)
 		}
 	}(stats)
 
 	if err != nil {
-		c.logger.Error("Failed to get domain stats", "err", err)
-		return
+		return fmt.Errorf("failed to get domain stats: %w", err)
 	}
 
+	uuids := make([]string, 0, len(stats))
 	for _, stat := range stats {
+		// NOTE: Stop issuing further calls once the scrape timed out.
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
 		uuid, err := stat.Domain.GetUUIDString()
 		if err != nil {
 			c.logger.Error("Failed to get domain UUID", "err", err)
+			c.connection.CountError(c.scrape.name, err)
+			continue
+		}
+
+		name, err := stat.Domain.GetName()
+		if err != nil {
+			c.logger.Error("Failed to get domain name", "err", err)
+			c.connection.CountError(c.scrape.name, err)
 			continue
 		}
 
-		c.collectNova(uuid, stat, ch)
-		c.collectState(uuid, stat, ch)
-		c.collectCPU(uuid, stat, ch)
-		c.collectBalloon(uuid, stat, ch)
-		c.collectVcpu(uuid, stat, ch)
-		c.collectNet(uuid, stat, ch)
-		c.collectBlock(uuid, stat, ch)
+		dom := domainLabels{uuid: uuid, name: name}
+		uuids = append(uuids, uuid)
+
+		var domainXML *DomainXML
+		if c.needsDomainXML() {
+			domainXML, err = getDomainXML(stat.Domain)
+			if err != nil {
+				c.logger.Error("Failed to get domain XML", "err", err)
+				c.connection.CountError(c.scrape.name, err)
+			}
+		}
+
+		if c.info && domainXML != nil {
+			c.collectInfo(dom, domainXML, ch)
+		}
+		c.collectNova(dom, stat, ch)
+		if c.noWait {
+			c.collectStatsIncomplete(dom, stat, ch)
+		}
+
+		if c.statsTypes&libvirt.DOMAIN_STATS_STATE != 0 && stat.State != nil {
+			c.collectState(dom, stat, ch)
+		}
+		c.collectCPU(dom, stat, ch)
+		if stat.Balloon != nil {
+			c.collectBalloon(dom, stat, ch)
+		}
+		c.collectVcpu(dom, stat, ch)
+		c.collectNet(dom, stat, ch)
+		if c.info && c.statsTypes&libvirt.DOMAIN_STATS_INTERFACE != 0 && domainXML != nil {
+			c.collectNetInfo(dom, domainXML, ch)
+		}
+		c.collectBlock(dom, stat, ch)
+		if c.info && c.statsTypes&libvirt.DOMAIN_STATS_BLOCK != 0 && domainXML != nil {
+			c.collectBlockInfo(dom, domainXML, ch)
+		}
+		if c.blockErrors && domainActive(stat) {
+			c.collectBlockErrors(dom, stat, ch)
+		}
+		if stat.Perf != nil {
+			c.collectPerf(dom, stat, ch)
+		}
+		c.collectIOThread(dom, stat, ch)
+		// NOTE: The domain XML, if got for the info metrics, spares the
+		//       call for the domains without iothreads.
+		if c.ioThreadInfo && (domainXML == nil || domainXML.IOThreads > 0) {
+			c.collectIOThreadInfo(dom, stat, ch)
+		}
+		if stat.Memory != nil {
+			c.collectMemory(dom, stat, ch)
+		}
+		if stat.DirtyRate != nil {
+			c.collectDirtyRate(dom, stat, ch)
+		}
+		if c.statsTypes&libvirt.DOMAIN_STATS_DIRTYRATE != 0 && c.dirtyRateCalcInterval > 0 {
+			c.startDirtyRateCalc(dom, stat)
+		}
+		if c.statsTypes&libvirt.DOMAIN_STATS_VM != 0 {
+			c.collectVM(dom, stat, ch)
+		}
 	}
+
+	c.pruneDirtyRateCalcs(uuids)
+
+	return nil
 }
 
-func (c *DomainStatsCollector) collectNova(uuid string, stat libvirt.DomainStats, ch chan<- prometheus.Metric) {
+// getAllDomainStats gets the stats of the domains, without waiting on those
+// busy with a job if enabled and supported by libvirt.
+func (c *DomainStatsCollector) getAllDomainStats(conn Connect, domains []Domain) ([]DomainStats, error) {
+	// NOTE: The state tells running domains apart, which are the only ones
+	//       that can be missing stats or have disk errors.  It also keeps
+	//       libvirt from returning every stat it supports when none of the
+	//       groups are enabled.
+	statsTypes := c.statsTypes | libvirt.DOMAIN_STATS_STATE
+
+	var flags libvirt.ConnectGetAllDomainStatsFlags
+	if c.backing {
+		flags |= libvirt.CONNECT_GET_ALL_DOMAINS_STATS_BACKING
+	}
+
+	if !c.noWait || c.noWaitUnsupported.Load() {
+		return conn.GetAllDomainStats(domains, statsTypes, flags)
+	}
+
+	stats, err := conn.GetAllDomainStats(domains, statsTypes, flags|libvirt.CONNECT_GET_ALL_DOMAINS_STATS_NOWAIT)
+
+	// NOTE: libvirt older than 4.5.0 rejects the flag as invalid.
+	var virErr libvirt.Error
+	if errors.As(err, &virErr) && virErr.Code == libvirt.ERR_INVALID_ARG {
+		c.logger.Warn("libvirt does not support getting stats without waiting on jobs", "err", err)
+		c.noWaitUnsupported.Store(true)
+
+		return conn.GetAllDomainStats(domains, statsTypes, flags)
+	}
+
+	return stats, err
+}
+
+// listDomains lists the domains selected by the filter, or returns nil if
+// every domain is selected so that libvirt lists them along with their
+// stats.
+func (c *DomainStatsCollector) listDomains(conn Connect) ([]Domain, error) {
+	if c.filter.IsEmpty() {
+		return nil, nil
+	}
+
+	domains, err := conn.ListAllDomains(c.filter.listFlags())
+	if err != nil {
+		return nil, err
+	}
+
+	selected := []Domain{}
+	for _, domain := range domains {
+		if c.selectDomain(domain) {
+			selected = append(selected, domain)
+		} else {
+			c.freeDomain(domain)
+		}
+	}
+
+	return selected, nil
+}
+
+func (c *DomainStatsCollector) selectDomain(domain Domain) bool {
+	uuid, err := domain.GetUUIDString()
+	if err != nil {
+		c.logger.Error("Failed to get domain UUID", "err", err)
+		c.connection.CountError(c.scrape.name, err)
+		return false
+	}
+
+	name, err := domain.GetName()
+	if err != nil {
+		c.logger.Error("Failed to get domain name", "err", err)
+		c.connection.CountError(c.scrape.name, err)
+		return false
+	}
+
+	metadata := &NovaMetadata{}
+	if c.filter.needsNova() {
+		// NOTE: Domains without Nova metadata, such as those not managed
+		//       by Nova, are matched as having no project nor flavor.
+		m, err := c.getNovaMetadata(domain)
+
+		var virErr libvirt.Error
+		switch {
+		case err == nil:
+			metadata = m
+		case errors.As(err, &virErr) && virErr.Code == libvirt.ERR_NO_DOMAIN_METADATA:
+		default:
+			c.logger.Error("Failed to get Nova metadata", "domain", name, "err", err)
+			c.connection.CountError(c.scrape.name, err)
+		}
+	}
+
+	return c.filter.matches(uuid, name, metadata)
+}
+
+func (c *DomainStatsCollector) freeDomain(domain Domain) {
+	err := domain.Free()
+	if err != nil {
+		c.logger.Error("Failed to free domain", "err", err)
+		c.connection.CountError(c.scrape.name, err)
+	}
+}
+
+// needsDomainXML returns whether any of the enabled metrics come from the
+// domain XML, which costs an extra call per domain.  The interface and
+// block info metrics are only collected along with the domain info.
+func (c *DomainStatsCollector) needsDomainXML() bool {
+	return c.info
+}
+
+// domainLabelValues returns the values of the labels of a domain metric,
+// see domainDesc.
+func (c *DomainStatsCollector) domainLabelValues(dom domainLabels, labels []string) []string {
+	labels = append([]string{dom.uuid}, labels...)
+	if c.NameLabel {
+		labels = append(labels, dom.name)
+	}
+
+	return labels
+}
+
+// domainMetric creates a metric of a single domain described with
+// domainDesc.
+func (c *DomainStatsCollector) domainMetric(
+	desc *prometheus.Desc, valueType prometheus.ValueType, value float64, dom domainLabels, labels ...string,
+) prometheus.Metric {
+	return prometheus.MustNewConstMetric(desc, valueType, value, c.domainLabelValues(dom, labels)...)
+}
+
+// sendDomainMetric sends the metrics of a single domain described with
+// namedDomainDesc.
+func (c *DomainStatsCollector) sendDomainMetric(
+	ch chan<- prometheus.Metric, desc *namedDesc, value float64, dom domainLabels, labels ...string,
+) {
+	desc.send(ch, value, c.domainLabelValues(dom, labels)...)
+}
+
+func (c *DomainStatsCollector) collectInfo(dom domainLabels, domainXML *DomainXML, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(
+		c.DomainInfo,
+		prometheus.GaugeValue,
+		1, dom.uuid, dom.name, domainXML.Title, domainXML.OS.Type.Value, domainXML.OS.Type.Machine, domainXML.Type,
+	)
+}
+
+func (c *DomainStatsCollector) collectNova(dom domainLabels, stat DomainStats, ch chan<- prometheus.Metric) {
 	if c.Nova {
 		metadata, err := c.getNovaMetadata(stat.Domain)
 
 		if err != nil {
 			c.logger.Error("Failed to get Nova metadata", "err", err)
+			c.connection.CountError(c.scrape.name, err)
 		} else {
-			ch <- prometheus.MustNewConstMetric(
+			ch <- c.domainMetric(
 				c.DomainSeconds,
 				prometheus.CounterValue,
-				metadata.Seconds, uuid, metadata.Flavor.Name, metadata.User.UUID, metadata.Project.UUID,
+				metadata.Seconds, dom, metadata.Flavor.Name, metadata.User.UUID, metadata.Project.UUID,
 			)
 		}
 	}
 }
 
-func (c *DomainStatsCollector) collectState(uuid string, stat libvirt.DomainStats, ch chan<- prometheus.Metric) {
-	ch <- prometheus.MustNewConstMetric(
+func (c *DomainStatsCollector) collectStatsIncomplete(dom domainLabels, stat DomainStats, ch chan<- prometheus.Metric) {
+	value := 0.0
+	if statsIncomplete(stat, c.statsTypes) {
+		value = 1
+	}
+
+	ch <- c.domainMetric(
+		c.DomainStatsIncomplete,
+		prometheus.GaugeValue,
+		value, dom,
+	)
+}
+
+// statsIncomplete returns whether libvirt skipped the stats of a running
+// domain that need its monitor, because the domain was busy with a job.
+// libvirt does not report it, so it is told by the balloon RSS and the
+// block request counters, which are always there otherwise.
+func statsIncomplete(stat DomainStats, statsTypes libvirt.DomainStatsTypes) bool {
+	if !domainActive(stat) {
+		return false
+	}
+
+	if statsTypes&libvirt.DOMAIN_STATS_BALLOON != 0 && (stat.Balloon == nil || !stat.Balloon.RssSet) {
+		return true
+	}
+
+	if statsTypes&libvirt.DOMAIN_STATS_BLOCK != 0 && len(stat.Block) > 0 {
+		for _, blockStats := range stat.Block {
+			if blockStats.RdReqsSet || blockStats.WrReqsSet {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// domainActive returns whether the domain is running, possibly paused.
+func domainActive(stat DomainStats) bool {
+	if stat.State == nil {
+		return false
+	}
+
+	switch stat.State.State {
+	case libvirt.DOMAIN_RUNNING, libvirt.DOMAIN_BLOCKED, libvirt.DOMAIN_PAUSED:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *DomainStatsCollector) collectState(dom domainLabels, stat DomainStats, ch chan<- prometheus.Metric) {
+	for state, name := range domainStates {
+		value := 0.0
+		if state == stat.State.State {
+			value = 1
+		}
+
+		ch <- c.domainMetric(
+			c.DomainState,
+			prometheus.GaugeValue,
+			value, dom, name,
+		)
+	}
+	ch <- c.domainMetric(
+		c.DomainStateReason,
+		prometheus.GaugeValue,
+		1, dom, domainStateName(stat.State.State), domainStateReasonName(stat.State.State, stat.State.Reason),
+	)
+	ch <- c.domainMetric(
 		c.DomainDomainState,
 		prometheus.GaugeValue,
-		float64(stat.State.State), uuid,
+		float64(stat.State.State), dom,
 	)
-	ch <- prometheus.MustNewConstMetric(
+	ch <- c.domainMetric(
 		c.DomainDomainStateReason,
 		prometheus.GaugeValue,
-		float64(stat.State.Reason), uuid,
+		float64(stat.State.Reason), dom,
 	)
 }
 
-func (c *DomainStatsCollector) collectCPU(uuid string, stat libvirt.DomainStats, ch chan<- prometheus.Metric) {
+func (c *DomainStatsCollector) collectCPU(dom domainLabels, stat DomainStats, ch chan<- prometheus.Metric) {
 	if stat.Cpu != nil {
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainCPUTime,
-			prometheus.CounterValue,
-			float64(stat.Cpu.Time), uuid,
+		c.sendDomainMetric(
+			ch, c.DomainCPUTime,
+			float64(stat.Cpu.Time), dom,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainCPUUser,
-			prometheus.CounterValue,
-			float64(stat.Cpu.User), uuid,
+		c.sendDomainMetric(
+			ch, c.DomainCPUUser,
+			float64(stat.Cpu.User), dom,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainCPUSystem,
-			prometheus.CounterValue,
-			float64(stat.Cpu.System), uuid,
+		c.sendDomainMetric(
+			ch, c.DomainCPUSystem,
+			float64(stat.Cpu.System), dom,
 		)
+
+		// NOTE: Cache monitors are only there on hosts with resctrl.
+		for _, monitor := range stat.Cpu.CacheMonitors {
+			for _, bank := range monitor.Banks {
+				if !bank.BytesSet {
+					continue
+				}
+
+				ch <- c.domainMetric(
+					c.DomainCPUCacheMonitor,
+					prometheus.GaugeValue,
+					float64(bank.Bytes), dom, monitor.Name, monitor.Vcpus, strconv.FormatUint(uint64(bank.ID), 10),
+				)
+			}
+		}
 	}
 }
 
-func (c *DomainStatsCollector) collectBalloon(uuid string, stat libvirt.DomainStats, ch chan<- prometheus.Metric) {
-	ch <- prometheus.MustNewConstMetric(
-		c.DomainBalloonCurrent,
-		prometheus.GaugeValue,
-		float64(stat.Balloon.Current), uuid,
+func (c *DomainStatsCollector) collectMemory(dom domainLabels, stat DomainStats, ch chan<- prometheus.Metric) {
+	// NOTE: Bandwidth monitors are only there on hosts with resctrl.
+	for _, monitor := range stat.Memory.BandwidthMonitor {
+		for _, node := range monitor.Nodes {
+			id := strconv.FormatUint(uint64(node.ID), 10)
+
+			if node.BytesLocalSet {
+				ch <- c.domainMetric(
+					c.DomainMemoryBandwidthLocal,
+					prometheus.CounterValue,
+					float64(node.BytesLocal), dom, monitor.Name, monitor.VCPUs, id,
+				)
+			}
+			if node.BytesTotalSet {
+				ch <- c.domainMetric(
+					c.DomainMemoryBandwidthTotal,
+					prometheus.CounterValue,
+					float64(node.BytesTotal), dom, monitor.Name, monitor.VCPUs, id,
+				)
+			}
+		}
+	}
+}
+
+func (c *DomainStatsCollector) collectBalloon(dom domainLabels, stat DomainStats, ch chan<- prometheus.Metric) {
+	c.sendDomainMetric(
+		ch, c.DomainBalloonCurrent,
+		float64(stat.Balloon.Current), dom,
 	)
-	ch <- prometheus.MustNewConstMetric(
-		c.DomainBalloonMaximum,
-		prometheus.GaugeValue,
-		float64(stat.Balloon.Maximum), uuid,
+	c.sendDomainMetric(
+		ch, c.DomainBalloonMaximum,
+		float64(stat.Balloon.Maximum), dom,
 	)
 	if stat.Balloon.SwapInSet {
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBalloonSwapIn,
-			prometheus.CounterValue,
-			float64(stat.Balloon.SwapIn), uuid,
+		c.sendDomainMetric(
+			ch, c.DomainBalloonSwapIn,
+			float64(stat.Balloon.SwapIn), dom,
 		)
 	}
 	if stat.Balloon.SwapOutSet {
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBalloonSwapOut,
-			prometheus.CounterValue,
-			float64(stat.Balloon.SwapOut), uuid,
+		c.sendDomainMetric(
+			ch, c.DomainBalloonSwapOut,
+			float64(stat.Balloon.SwapOut), dom,
 		)
 	}
 	if stat.Balloon.MajorFaultSet {
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBalloonMajorFault,
-			prometheus.CounterValue,
-			float64(stat.Balloon.MajorFault), uuid,
+		c.sendDomainMetric(
+			ch, c.DomainBalloonMajorFault,
+			float64(stat.Balloon.MajorFault), dom,
 		)
 	}
 	if stat.Balloon.MinorFaultSet {
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBalloonMinorFault,
-			prometheus.CounterValue,
-			float64(stat.Balloon.MinorFault), uuid,
+		c.sendDomainMetric(
+			ch, c.DomainBalloonMinorFault,
+			float64(stat.Balloon.MinorFault), dom,
 		)
 	}
 	if stat.Balloon.UnusedSet {
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBalloonUnused,
-			prometheus.GaugeValue,
-			float64(stat.Balloon.Unused), uuid,
+		c.sendDomainMetric(
+			ch, c.DomainBalloonUnused,
+			float64(stat.Balloon.Unused), dom,
 		)
 	}
 	if stat.Balloon.AvailableSet {
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBalloonAvailable,
-			prometheus.GaugeValue,
-			float64(stat.Balloon.Available), uuid,
+		c.sendDomainMetric(
+			ch, c.DomainBalloonAvailable,
+			float64(stat.Balloon.Available), dom,
 		)
 	}
 	if stat.Balloon.RssSet {
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBalloonRss,
-			prometheus.GaugeValue,
-			float64(stat.Balloon.Rss), uuid,
+		c.sendDomainMetric(
+			ch, c.DomainBalloonRss,
+			float64(stat.Balloon.Rss), dom,
 		)
 	}
 	if stat.Balloon.UsableSet {
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBalloonUsable,
-			prometheus.GaugeValue,
-			float64(stat.Balloon.Usable), uuid,
+		c.sendDomainMetric(
+			ch, c.DomainBalloonUsable,
+			float64(stat.Balloon.Usable), dom,
 		)
 	}
 	if stat.Balloon.DiskCachesSet {
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBalloonDiskCaches,
-			prometheus.GaugeValue,
-			float64(stat.Balloon.DiskCaches), uuid,
+		c.sendDomainMetric(
+			ch, c.DomainBalloonDiskCaches,
+			float64(stat.Balloon.DiskCaches), dom,
 		)
 	}
 	if stat.Balloon.HugetlbPgAllocSet {
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBalloonHugetlbPgAlloc,
-			prometheus.CounterValue,
-			float64(stat.Balloon.HugetlbPgAlloc), uuid,
+		c.sendDomainMetric(
+			ch, c.DomainBalloonHugetlbPgAlloc,
+			float64(stat.Balloon.HugetlbPgAlloc), dom,
 		)
 	}
 	if stat.Balloon.HugetlbPgFailSet {
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBalloonHugetlbPgFail,
-			prometheus.CounterValue,
-			float64(stat.Balloon.HugetlbPgFail), uuid,
+		c.sendDomainMetric(
+			ch, c.DomainBalloonHugetlbPgFail,
+			float64(stat.Balloon.HugetlbPgFail), dom,
 		)
 	}
 }
 
-func (c *DomainStatsCollector) collectVcpu(uuid string, stat libvirt.DomainStats, ch chan<- prometheus.Metric) {
+func (c *DomainStatsCollector) collectVcpu(dom domainLabels, stat DomainStats, ch chan<- prometheus.Metric) {
+	var delay uint64
+	delaySet := false
+
 	for vcpu, vcpuStats := range stat.Vcpu {
-		ch <- prometheus.MustNewConstMetric(
+		ch <- c.domainMetric(
 			c.DomainVcpuState,
 			prometheus.GaugeValue,
-			float64(vcpuStats.State), uuid, strconv.Itoa(vcpu),
+			float64(vcpuStats.State), dom, strconv.Itoa(vcpu),
+		)
+		c.sendDomainMetric(
+			ch, c.DomainVcpuTime,
+			float64(vcpuStats.Time), dom, strconv.Itoa(vcpu),
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainVcpuTime,
+		if vcpuStats.WaitSet {
+			ch <- c.domainMetric(
+				c.DomainVcpuWait,
+				prometheus.CounterValue,
+				float64(vcpuStats.Wait)*nanoseconds, dom, strconv.Itoa(vcpu),
+			)
+		}
+		if vcpuStats.DelaySet {
+			ch <- c.domainMetric(
+				c.DomainVcpuDelay,
+				prometheus.CounterValue,
+				float64(vcpuStats.Delay)*nanoseconds, dom, strconv.Itoa(vcpu),
+			)
+
+			delay += vcpuStats.Delay
+			delaySet = true
+		}
+		if vcpuStats.HaltedSet {
+			value := 0.0
+			if vcpuStats.Halted {
+				value = 1
+			}
+
+			ch <- c.domainMetric(
+				c.DomainVcpuHalted,
+				prometheus.GaugeValue,
+				value, dom, strconv.Itoa(vcpu),
+			)
+		}
+	}
+
+	// NOTE: The delay of the whole domain, like steal time within a guest,
+	//       shows how much it is held back by the other loads of the host.
+	if delaySet {
+		ch <- c.domainMetric(
+			c.DomainCPUDelay,
 			prometheus.CounterValue,
-			float64(vcpuStats.Time), uuid, strconv.Itoa(vcpu),
+			float64(delay)*nanoseconds, dom,
 		)
 	}
 }
 
-func (c *DomainStatsCollector) collectNet(uuid string, stat libvirt.DomainStats, ch chan<- prometheus.Metric) {
+func (c *DomainStatsCollector) collectNet(dom domainLabels, stat DomainStats, ch chan<- prometheus.Metric) {
 	for _, netStats := range stat.Net {
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainNetRxBytes,
-			prometheus.CounterValue,
-			float64(netStats.RxBytes), uuid, netStats.Name,
+		c.sendDomainMetric(
+			ch, c.DomainNetRxBytes,
+			float64(netStats.RxBytes), dom, netStats.Name,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainNetRxPkts,
-			prometheus.CounterValue,
-			float64(netStats.RxPkts), uuid, netStats.Name,
+		c.sendDomainMetric(
+			ch, c.DomainNetRxPkts,
+			float64(netStats.RxPkts), dom, netStats.Name,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainNetRxErrs,
-			prometheus.CounterValue,
-			float64(netStats.RxErrs), uuid, netStats.Name,
+		c.sendDomainMetric(
+			ch, c.DomainNetRxErrs,
+			float64(netStats.RxErrs), dom, netStats.Name,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainNetRxDrop,
-			prometheus.CounterValue,
-			float64(netStats.RxDrop), uuid, netStats.Name,
+		c.sendDomainMetric(
+			ch, c.DomainNetRxDrop,
+			float64(netStats.RxDrop), dom, netStats.Name,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainNetTxBytes,
-			prometheus.CounterValue,
-			float64(netStats.TxBytes), uuid, netStats.Name,
+		c.sendDomainMetric(
+			ch, c.DomainNetTxBytes,
+			float64(netStats.TxBytes), dom, netStats.Name,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainNetTxPkts,
-			prometheus.CounterValue,
-			float64(netStats.TxPkts), uuid, netStats.Name,
+		c.sendDomainMetric(
+			ch, c.DomainNetTxPkts,
+			float64(netStats.TxPkts), dom, netStats.Name,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainNetTxErrs,
-			prometheus.CounterValue,
-			float64(netStats.TxErrs), uuid, netStats.Name,
+		c.sendDomainMetric(
+			ch, c.DomainNetTxErrs,
+			float64(netStats.TxErrs), dom, netStats.Name,
+		)
+		c.sendDomainMetric(
+			ch, c.DomainNetTxDrop,
+			float64(netStats.TxDrop), dom, netStats.Name,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainNetTxDrop,
+	}
+}
+
+func (c *DomainStatsCollector) collectNetInfo(dom domainLabels, domainXML *DomainXML, ch chan<- prometheus.Metric) {
+	for _, iface := range domainXML.Devices.Interfaces {
+		ch <- c.domainMetric(
+			c.DomainNetInfo,
 			prometheus.GaugeValue,
-			float64(netStats.TxDrop), uuid, netStats.Name,
+			1, dom, iface.Target.Dev, iface.MAC.Address, iface.Source.Bridge, iface.Source.Network,
+			iface.Source.PortGroup, iface.Model.Type, iface.VLAN.String(), iface.VirtualPort.Parameters.InterfaceID,
 		)
 	}
 }
 
-func (c *DomainStatsCollector) collectBlock(uuid string, stat libvirt.DomainStats, ch chan<- prometheus.Metric) {
-	for device, blockStats := range stat.Block {
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBlockRdReqs,
-			prometheus.CounterValue,
-			float64(blockStats.RdReqs), uuid, strconv.Itoa(device), blockStats.Path,
+func (c *DomainStatsCollector) collectBlock(dom domainLabels, stat DomainStats, ch chan<- prometheus.Metric) {
+	for _, blockStats := range stat.Block {
+		labels := []string{blockStats.Name, blockStats.Path}
+		if c.backing {
+			labels = append(labels, backingIndex(blockStats))
+		}
+
+		c.sendDomainMetric(
+			ch, c.DomainBlockRdReqs,
+			float64(blockStats.RdReqs), dom, labels...,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBlockRdBytes,
-			prometheus.CounterValue,
-			float64(blockStats.RdBytes), uuid, strconv.Itoa(device), blockStats.Path,
+		c.sendDomainMetric(
+			ch, c.DomainBlockRdBytes,
+			float64(blockStats.RdBytes), dom, labels...,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBlockRdTimes,
-			prometheus.CounterValue,
-			float64(blockStats.RdTimes), uuid, strconv.Itoa(device), blockStats.Path,
+		c.sendDomainMetric(
+			ch, c.DomainBlockRdTimes,
+			float64(blockStats.RdTimes), dom, labels...,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBlockWrReqs,
-			prometheus.CounterValue,
-			float64(blockStats.WrReqs), uuid, strconv.Itoa(device), blockStats.Path,
+		c.sendDomainMetric(
+			ch, c.DomainBlockWrReqs,
+			float64(blockStats.WrReqs), dom, labels...,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBlockWrBytes,
-			prometheus.CounterValue,
-			float64(blockStats.WrBytes), uuid, strconv.Itoa(device), blockStats.Path,
+		c.sendDomainMetric(
+			ch, c.DomainBlockWrBytes,
+			float64(blockStats.WrBytes), dom, labels...,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBlockWrTimes,
-			prometheus.CounterValue,
-			float64(blockStats.WrTimes), uuid, strconv.Itoa(device), blockStats.Path,
+		c.sendDomainMetric(
+			ch, c.DomainBlockWrTimes,
+			float64(blockStats.WrTimes), dom, labels...,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBlockFlReqs,
-			prometheus.CounterValue,
-			float64(blockStats.FlReqs), uuid, strconv.Itoa(device), blockStats.Path,
+		c.sendDomainMetric(
+			ch, c.DomainBlockFlReqs,
+			float64(blockStats.FlReqs), dom, labels...,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBlockFlTimes,
-			prometheus.CounterValue,
-			float64(blockStats.FlTimes), uuid, strconv.Itoa(device), blockStats.Path,
+		c.sendDomainMetric(
+			ch, c.DomainBlockFlTimes,
+			float64(blockStats.FlTimes), dom, labels...,
+		)
+		c.sendDomainMetric(
+			ch, c.DomainBlockAllocation,
+			float64(blockStats.Allocation), dom, labels...,
+		)
+		c.sendDomainMetric(
+			ch, c.DomainBlockCapacity,
+			float64(blockStats.Capacity), dom, labels...,
+		)
+		c.sendDomainMetric(
+			ch, c.DomainBlockPhysical,
+			float64(blockStats.Physical), dom, labels...,
+		)
+
+		// NOTE: The threshold is only reported once it has been set.
+		if blockStats.ThresholdSet {
+			ch <- c.domainMetric(
+				c.DomainBlockThreshold,
+				prometheus.GaugeValue,
+				float64(blockStats.Threshold), dom, labels...,
+			)
+		}
+	}
+}
+
+// domainDiskErrors maps the I/O errors libvirt reports for disks to their
+// label.
+var domainDiskErrors = map[libvirt.DomainDiskErrorCode]string{
+	libvirt.DOMAIN_DISK_ERROR_UNSPEC:   "unspecified",
+	libvirt.DOMAIN_DISK_ERROR_NO_SPACE: "no_space",
+}
+
+func (c *DomainStatsCollector) collectBlockErrors(dom domainLabels, stat DomainStats, ch chan<- prometheus.Metric) {
+	// NOTE: Getting the disk errors of a domain waits on its jobs, skip it
+	//       for the domains that were found busy with one.
+	if c.noWait && statsIncomplete(stat, c.statsTypes) {
+		return
+	}
+
+	diskErrors, err := stat.Domain.GetDiskErrors(0)
+	if err != nil {
+		c.logger.Error("Failed to get disk errors", "uuid", dom.uuid, "err", err)
+		c.connection.CountError(c.scrape.name, err)
+		return
+	}
+
+	// NOTE: Only disks with an error are reported, the others are taken
+	//       from the block stats so that errors can be alerted on with a
+	//       plain comparison.
+	devices := []string{}
+	for _, blockStats := range stat.Block {
+		if !slices.Contains(devices, blockStats.Name) {
+			devices = append(devices, blockStats.Name)
+		}
+	}
+	for _, diskError := range diskErrors {
+		if !slices.Contains(devices, diskError.Disk) {
+			devices = append(devices, diskError.Disk)
+		}
+	}
+
+	for _, device := range devices {
+		for code, name := range domainDiskErrors {
+			value := 0.0
+			if slices.Contains(diskErrors, libvirt.DomainDiskError{Disk: device, Error: code}) {
+				value = 1
+			}
+
+			ch <- c.domainMetric(
+				c.DomainBlockErrors,
+				prometheus.GaugeValue,
+				value, dom, device, name,
+			)
+		}
+	}
+}
+
+// backingIndex returns the index of the layer of the backing chain the block
+// stats are for, as found in the domain XML, or nothing for the top layer if
+// libvirt does not index it.
+func backingIndex(blockStats libvirt.DomainStatsBlock) string {
+	if !blockStats.BackingIndexSet {
+		return ""
+	}
+
+	return strconv.FormatUint(uint64(blockStats.BackingIndex), 10)
+}
+
+func (c *DomainStatsCollector) collectBlockInfo(dom domainLabels, domainXML *DomainXML, ch chan<- prometheus.Metric) {
+	for _, disk := range domainXML.Devices.Disks {
+		ch <- c.domainMetric(
+			c.DomainBlockInfo,
+			prometheus.GaugeValue,
+			1, dom, disk.Target.Dev, disk.Target.Bus, disk.Driver.Type, disk.Driver.Cache, disk.Driver.IO,
+			disk.Driver.Discard, disk.Serial, disk.Driver.IOThread,
+		)
+	}
+}
+
+// collectPerf reports the perf events enabled on the domain, libvirt only
+// sets those.
+func (c *DomainStatsCollector) collectPerf(dom domainLabels, stat DomainStats, ch chan<- prometheus.Metric) {
+	for i, event := range domainPerfEvents {
+		value, ok := event.value(stat.Perf)
+		if !ok {
+			continue
+		}
+
+		ch <- c.domainMetric(
+			c.DomainPerf[i],
+			event.valueType,
+			float64(value)*event.scale, dom,
+		)
+	}
+}
+
+// domainDirtyRateStatuses maps the statuses of dirty rate calculations to
+// their label.
+var domainDirtyRateStatuses = map[libvirt.DomainDirtyRateStatus]string{
+	libvirt.DOMAIN_DIRTYRATE_UNSTARTED: "unstarted",
+	libvirt.DOMAIN_DIRTYRATE_MEASURING: "measuring",
+	libvirt.DOMAIN_DIRTYRATE_MEASURED:  "measured",
+}
+
+func (c *DomainStatsCollector) collectDirtyRate(dom domainLabels, stat DomainStats, ch chan<- prometheus.Metric) {
+	dirtyRate := stat.DirtyRate
+
+	if dirtyRate.CalcStatusSet {
+		for status, name := range domainDirtyRateStatuses {
+			value := 0.0
+			if status == libvirt.DomainDirtyRateStatus(dirtyRate.CalcStatus) {
+				value = 1
+			}
+
+			ch <- c.domainMetric(
+				c.DomainDirtyRateCalcStatus,
+				prometheus.GaugeValue,
+				value, dom, name,
+			)
+		}
+	}
+	if dirtyRate.CalcStartTimeSet {
+		ch <- c.domainMetric(
+			c.DomainDirtyRateCalcStartTime,
+			prometheus.GaugeValue,
+			float64(dirtyRate.CalcStartTime), dom,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBlockAllocation,
+	}
+	if dirtyRate.CalcPeriodSet {
+		ch <- c.domainMetric(
+			c.DomainDirtyRateCalcPeriod,
 			prometheus.GaugeValue,
-			float64(blockStats.Allocation), uuid, strconv.Itoa(device), blockStats.Path,
+			float64(dirtyRate.CalcPeriod), dom,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBlockCapacity,
+	}
+
+	// NOTE: The rates are only there once a calculation was measured.
+	if dirtyRate.MegabytesPerSecondSet {
+		ch <- c.domainMetric(
+			c.DomainDirtyRate,
 			prometheus.GaugeValue,
-			float64(blockStats.Capacity), uuid, strconv.Itoa(device), blockStats.Path,
+			float64(dirtyRate.MegabytesPerSecond)*mebibytes, dom,
 		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DomainBlockPhysical,
+	}
+	for vcpu, vcpuRate := range dirtyRate.VCPUS {
+		if !vcpuRate.MegabytesPerSecondSet {
+			continue
+		}
+
+		ch <- c.domainMetric(
+			c.DomainDirtyRateVcpu,
 			prometheus.GaugeValue,
-			float64(blockStats.Physical), uuid, strconv.Itoa(device), blockStats.Path,
+			float64(vcpuRate.MegabytesPerSecond)*mebibytes, dom, strconv.Itoa(vcpu),
 		)
 	}
 }
 
-func (c *DomainStatsCollector) getNovaMetadata(domain *libvirt.Domain) (*NovaMetadata, error) {
+// startDirtyRateCalc starts a dirty rate calculation on the domain if it is
+// running and none was started within the calculation interval, so that
+// its rate is there for the following scrapes.
+func (c *DomainStatsCollector) startDirtyRateCalc(dom domainLabels, stat DomainStats) {
+	if stat.State == nil || stat.State.State != libvirt.DOMAIN_RUNNING {
+		return
+	}
+	if stat.DirtyRate != nil && libvirt.DomainDirtyRateStatus(stat.DirtyRate.CalcStatus) == libvirt.DOMAIN_DIRTYRATE_MEASURING {
+		return
+	}
+	// NOTE: Starting a calculation waits on the jobs of the domain.
+	if c.noWait && statsIncomplete(stat, c.statsTypes) {
+		return
+	}
+
+	calcs := c.dirtyRateCalcs()
+	calcs.mu.Lock()
+	last, ok := calcs.started[dom.uuid]
+	if ok && now().Sub(last) < c.dirtyRateCalcInterval {
+		calcs.mu.Unlock()
+		return
+	}
+
+	// NOTE: The calculation is reserved so that concurrent scrapes do not
+	//       start it twice, without holding the lock across the call.
+	calcs.started[dom.uuid] = now()
+	calcs.mu.Unlock()
+
+	err := stat.Domain.StartDirtyRateCalc(int(c.dirtyRateCalcPeriod.Seconds()), 0)
+	if err != nil {
+		c.logger.Error("Failed to start dirty rate calculation", "uuid", dom.uuid, "err", err)
+		c.connection.CountError(c.scrape.name, err)
+
+		calcs.mu.Lock()
+		if ok {
+			calcs.started[dom.uuid] = last
+		} else {
+			delete(calcs.started, dom.uuid)
+		}
+		calcs.mu.Unlock()
+	}
+}
+
+// pruneDirtyRateCalcs forgets the calculations started on domains that are
+// gone.
+func (c *DomainStatsCollector) pruneDirtyRateCalcs(uuids []string) {
+	calcs := c.dirtyRateCalcs()
+	calcs.mu.Lock()
+	defer calcs.mu.Unlock()
+
+	for uuid := range calcs.started {
+		if !slices.Contains(uuids, uuid) {
+			delete(calcs.started, uuid)
+		}
+	}
+}
+
+// dirtyRateCalcs holds when a dirty rate calculation was last started on
+// each domain of a connection, keyed by UUID.
+type dirtyRateCalcs struct {
+	mu      sync.Mutex
+	started map[string]time.Time
+}
+
+// dirtyRateCalcsKey is the key the calculations started are kept under by
+// the connection.
+type dirtyRateCalcsKey struct{}
+
+// dirtyRateCalcs returns the calculations started on the domains of the
+// connection.
+func (c *DomainStatsCollector) dirtyRateCalcs() *dirtyRateCalcs {
+	calcs := c.connection.value(dirtyRateCalcsKey{}, func() any {
+		return &dirtyRateCalcs{started: map[string]time.Time{}}
+	})
+
+	return calcs.(*dirtyRateCalcs)
+}
+
+func (c *DomainStatsCollector) collectIOThread(dom domainLabels, stat DomainStats, ch chan<- prometheus.Metric) {
+	// NOTE: The iothreads are indexed by their ID, those missing or not
+	//       supporting polling are left empty.
+	for id, ioThread := range stat.IOThread {
+		if !ioThread.PollMaxNSSet {
+			continue
+		}
+
+		ch <- c.domainMetric(
+			c.DomainIOThreadPollMax,
+			prometheus.GaugeValue,
+			float64(ioThread.PollMaxNS)*nanoseconds, dom, strconv.Itoa(id),
+		)
+		if ioThread.PollGrowSet {
+			ch <- c.domainMetric(
+				c.DomainIOThreadPollGrow,
+				prometheus.GaugeValue,
+				pollValue(ioThread.PollGrow, ioThread.PollGrow64), dom, strconv.Itoa(id),
+			)
+		}
+		if ioThread.PollShrinkSet {
+			ch <- c.domainMetric(
+				c.DomainIOThreadPollShrink,
+				prometheus.GaugeValue,
+				pollValue(ioThread.PollShrink, ioThread.PollShrink64), dom, strconv.Itoa(id),
+			)
+		}
+	}
+}
+
+// pollValue returns a polling parameter of an iothread, which libvirt
+// reports as a 32 bit integer in older versions and a 64 bit one since.
+func pollValue(value uint, value64 uint64) float64 {
+	if value64 != 0 {
+		return float64(value64)
+	}
+
+	return float64(value)
+}
+
+func (c *DomainStatsCollector) collectIOThreadInfo(dom domainLabels, stat DomainStats, ch chan<- prometheus.Metric) {
+	// NOTE: Getting the iothreads of a running domain waits on its jobs,
+	//       skip it for the domains that were found busy with one.
+	if !domainActive(stat) || (c.noWait && statsIncomplete(stat, c.statsTypes)) {
+		return
+	}
+
+	ioThreads, err := stat.Domain.GetIOThreadInfo(libvirt.DOMAIN_AFFECT_LIVE)
+	if err != nil {
+		c.logger.Error("Failed to get iothreads", "uuid", dom.uuid, "err", err)
+		c.connection.CountError(c.scrape.name, err)
+		return
+	}
+
+	for _, ioThread := range ioThreads {
+		ch <- c.domainMetric(
+			c.DomainIOThreadInfo,
+			prometheus.GaugeValue,
+			1, dom, strconv.FormatUint(uint64(ioThread.IOThreadID), 10), cpuList(ioThread.CpuMap),
+		)
+	}
+}
+
+// cpuList formats a map of host cpus as a list of ranges, such as "0-3,8".
+func cpuList(cpuMap []bool) string {
+	ranges := []string{}
+
+	for start := 0; start < len(cpuMap); start++ {
+		if !cpuMap[start] {
+			continue
+		}
+
+		end := start
+		for end+1 < len(cpuMap) && cpuMap[end+1] {
+			end++
+		}
+
+		if start == end {
+			ranges = append(ranges, strconv.Itoa(start))
+		} else {
+			ranges = append(ranges, strconv.Itoa(start)+"-"+strconv.Itoa(end))
+		}
+
+		start = end
+	}
+
+	return strings.Join(ranges, ",")
+}
+
+func (c *DomainStatsCollector) getNovaMetadata(domain Domain) (*NovaMetadata, error) {
+	// NOTE: The live metadata is only there for running domains, the
+	//       current one is that of the persistent definition otherwise.
 	data, err := domain.GetMetadata(
 		libvirt.DOMAIN_METADATA_ELEMENT,
 		"http://openstack.org/xmlns/libvirt/nova/1.1",
-		libvirt.DOMAIN_AFFECT_LIVE,
+		libvirt.DOMAIN_AFFECT_CURRENT,
 	)
 	if err != nil {
 		return nil, err
@@ -737,7 +1775,7 @@ func (c *DomainStatsCollector) getNovaMetadata(domain *libvirt.Domain) (*NovaMet
 		return nil, err
 	}
 
-	m.Seconds = time.Since(creationTime).Seconds()
+	m.Seconds = now().Sub(creationTime).Seconds()
 
 	return m, nil
 }
@@ -0,0 +1,1395 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+	"libvirt.org/go/libvirt"
+)
+
+const testUUID = "6c9a6a04-3e1c-4e6b-a33f-0b3d1a8e2f6d"
+
+var testDomain = domainLabels{uuid: testUUID, name: "instance-00000001"}
+
+const testDomainXML = `<domain type="kvm">
+  <name>instance-00000001</name>
+  <uuid>6c9a6a04-3e1c-4e6b-a33f-0b3d1a8e2f6d</uuid>
+  <title>web</title>
+  <iothreads>1</iothreads>
+  <os>
+    <type arch="x86_64" machine="pc-q35-8.2">hvm</type>
+  </os>
+  <devices>
+    <disk type="file" device="disk">
+      <driver name="qemu" type="qcow2" cache="none" io="native" discard="unmap"/>
+      <source file="/var/lib/nova/instances/disk"/>
+      <target dev="vda" bus="virtio"/>
+      <serial>6c9a6a04</serial>
+    </disk>
+    <disk type="network" device="disk">
+      <driver name="qemu" type="raw" cache="writeback" iothread="1"/>
+      <source protocol="rbd" name="volumes/volume-1"/>
+      <target dev="vdb" bus="virtio"/>
+    </disk>
+    <interface type="bridge">
+      <mac address="fa:16:3e:00:00:01"/>
+      <source bridge="br-int"/>
+      <virtualport type="openvswitch">
+        <parameters interfaceid="3f2a1b0c-port"/>
+      </virtualport>
+      <target dev="tap3f2a1b0c"/>
+      <model type="virtio"/>
+    </interface>
+    <interface type="network">
+      <mac address="52:54:00:00:00:02"/>
+      <source network="default" portgroup="engineering"/>
+      <vlan trunk="yes">
+        <tag id="42"/>
+        <tag id="47" nativeMode="untagged"/>
+      </vlan>
+      <target dev="vnet1"/>
+      <model type="e1000"/>
+    </interface>
+  </devices>
+</domain>`
+
+const novaMetadata = `<nova:instance xmlns:nova="http://openstack.org/xmlns/libvirt/nova/1.1">
+  <nova:name>test</nova:name>
+  <nova:creationTime>2024-01-01 00:00:00</nova:creationTime>
+  <nova:flavor name="m1.small">
+    <nova:memory>2048</nova:memory>
+  </nova:flavor>
+  <nova:owner>
+    <nova:user uuid="8d2a1b3c-user">admin</nova:user>
+    <nova:project uuid="4f5e6d7c-project">admin</nova:project>
+  </nova:owner>
+</nova:instance>`
+
+func newTestDomainStatsCollector(conn Connect, opts *Options) *DomainStatsCollector {
+	return NewDomainStatsCollector(promslog.NewNopLogger(), newFakeConnection(conn), opts)
+}
+
+func testDomainStats(stats libvirt.DomainStats) DomainStats {
+	return DomainStats{
+		DomainStats: stats,
+		Domain: &fakeDomain{
+			uuid:     testUUID,
+			name:     testDomain.name,
+			xml:      testDomainXML,
+			metadata: novaMetadata,
+		},
+	}
+}
+
+type domainStatsTest struct {
+	name     string
+	stats    libvirt.DomainStats
+	expected string
+}
+
+func runDomainStatsTests(
+	t *testing.T, tests []domainStatsTest,
+	collect func(c *DomainStatsCollector, stat DomainStats, ch chan<- prometheus.Metric),
+) {
+	t.Helper()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestDomainStatsCollector(&fakeConnect{}, DefaultOptions())
+			stat := testDomainStats(tt.stats)
+
+			err := testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+				collect(c, stat, ch)
+			}), strings.NewReader(tt.expected))
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCollectNova(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)
+	}
+	t.Cleanup(func() {
+		now = time.Now
+	})
+
+	tests := []struct {
+		name     string
+		nova     bool
+		domain   *fakeDomain
+		expected string
+	}{
+		{
+			name:   "metadata",
+			nova:   true,
+			domain: &fakeDomain{uuid: testUUID, metadata: novaMetadata},
+			expected: `
+# HELP libvirtd_domain_seconds seconds since creation time
+# TYPE libvirtd_domain_seconds counter
+libvirtd_domain_seconds{instance_type="m1.small",project_id="4f5e6d7c-project",user_id="8d2a1b3c-user",uuid="` + testUUID + `"} 3600
+`,
+		},
+		{
+			name:     "missing metadata",
+			nova:     true,
+			domain:   &fakeDomain{uuid: testUUID},
+			expected: ``,
+		},
+		{
+			name:     "disabled",
+			nova:     false,
+			domain:   &fakeDomain{uuid: testUUID, metadata: novaMetadata},
+			expected: ``,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Nova = tt.nova
+
+			c := newTestDomainStatsCollector(&fakeConnect{}, opts)
+			stat := DomainStats{Domain: tt.domain}
+
+			err := testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+				c.collectNova(testDomain, stat, ch)
+			}), strings.NewReader(tt.expected))
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// parseTestDomainXML parses testDomainXML.
+func parseTestDomainXML(t *testing.T) *DomainXML {
+	t.Helper()
+
+	domainXML, err := getDomainXML(&fakeDomain{xml: testDomainXML})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return domainXML
+}
+
+func TestCollectInfo(t *testing.T) {
+	domainXML := parseTestDomainXML(t)
+
+	runDomainStatsTests(t, []domainStatsTest{
+		{
+			name: "info",
+			expected: `
+# HELP libvirtd_domain_info information about the domain
+# TYPE libvirtd_domain_info gauge
+libvirtd_domain_info{hypervisor_type="kvm",machine_type="pc-q35-8.2",name="instance-00000001",os_type="hvm",title="web",uuid="` + testUUID + `"} 1
+`,
+		},
+	}, func(c *DomainStatsCollector, stat DomainStats, ch chan<- prometheus.Metric) {
+		c.collectInfo(testDomain, domainXML, ch)
+	})
+}
+
+func TestDomainStatsCollectorNameLabel(t *testing.T) {
+	opts := DefaultOptions()
+	opts.DomainNameLabel = true
+
+	c := newTestDomainStatsCollector(&fakeConnect{}, opts)
+	stat := testDomainStats(libvirt.DomainStats{
+		Cpu: &libvirt.DomainStatsCPU{Time: 1, User: 2, System: 3},
+	})
+
+	expected := `
+# HELP libvirtd_domain_cpu_time total cpu time spent for this domain in nanoseconds
+# TYPE libvirtd_domain_cpu_time counter
+libvirtd_domain_cpu_time{name="instance-00000001",uuid="` + testUUID + `"} 1
+`
+	err := testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.collectCPU(testDomain, stat, ch)
+	}), strings.NewReader(expected), "libvirtd_domain_cpu_time")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDomainStatsCollectorNamingScheme(t *testing.T) {
+	legacy := `
+# HELP libvirtd_domain_balloon_current the memory in kiB currently used
+# TYPE libvirtd_domain_balloon_current gauge
+libvirtd_domain_balloon_current{uuid="` + testUUID + `"} 2048
+# HELP libvirtd_domain_cpu_time total cpu time spent for this domain in nanoseconds
+# TYPE libvirtd_domain_cpu_time counter
+libvirtd_domain_cpu_time{uuid="` + testUUID + `"} 1.5e+09
+`
+	conventional := `
+# HELP libvirtd_domain_balloon_current_bytes the memory in bytes currently used
+# TYPE libvirtd_domain_balloon_current_bytes gauge
+libvirtd_domain_balloon_current_bytes{uuid="` + testUUID + `"} 2.097152e+06
+# HELP libvirtd_domain_cpu_seconds_total total cpu time spent for this domain in seconds
+# TYPE libvirtd_domain_cpu_seconds_total counter
+libvirtd_domain_cpu_seconds_total{uuid="` + testUUID + `"} 1.5
+`
+
+	tests := []struct {
+		scheme   NamingScheme
+		expected string
+	}{
+		{NamingLegacy, legacy},
+		{NamingConventional, conventional},
+		{NamingBoth, legacy + conventional},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.scheme), func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.NamingScheme = tt.scheme
+
+			c := newTestDomainStatsCollector(&fakeConnect{}, opts)
+			stat := testDomainStats(libvirt.DomainStats{
+				Cpu:     &libvirt.DomainStatsCPU{Time: 1500000000},
+				Balloon: &libvirt.DomainStatsBalloon{Current: 2048},
+			})
+
+			err := testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+				c.collectCPU(testDomain, stat, ch)
+				c.collectBalloon(testDomain, stat, ch)
+			}), strings.NewReader(tt.expected),
+				"libvirtd_domain_cpu_time", "libvirtd_domain_cpu_seconds_total",
+				"libvirtd_domain_balloon_current", "libvirtd_domain_balloon_current_bytes",
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCollectState(t *testing.T) {
+	runDomainStatsTests(t, []domainStatsTest{
+		{
+			name: "paused on I/O error",
+			stats: libvirt.DomainStats{
+				State: &libvirt.DomainStatsState{
+					State:  libvirt.DOMAIN_PAUSED,
+					Reason: int(libvirt.DOMAIN_PAUSED_IOERROR),
+				},
+			},
+			expected: `
+# HELP libvirtd_domain_domain_state state of the VM (virDomainState enum)
+# TYPE libvirtd_domain_domain_state gauge
+libvirtd_domain_domain_state{uuid="` + testUUID + `"} 3
+# HELP libvirtd_domain_domain_state_reason reason for entering given state (virDomain*Reason enum)
+# TYPE libvirtd_domain_domain_state_reason gauge
+libvirtd_domain_domain_state_reason{uuid="` + testUUID + `"} 5
+# HELP libvirtd_domain_state whether the domain is in the given state
+# TYPE libvirtd_domain_state gauge
+libvirtd_domain_state{state="blocked",uuid="` + testUUID + `"} 0
+libvirtd_domain_state{state="crashed",uuid="` + testUUID + `"} 0
+libvirtd_domain_state{state="nostate",uuid="` + testUUID + `"} 0
+libvirtd_domain_state{state="paused",uuid="` + testUUID + `"} 1
+libvirtd_domain_state{state="pmsuspended",uuid="` + testUUID + `"} 0
+libvirtd_domain_state{state="running",uuid="` + testUUID + `"} 0
+libvirtd_domain_state{state="shutdown",uuid="` + testUUID + `"} 0
+libvirtd_domain_state{state="shutoff",uuid="` + testUUID + `"} 0
+# HELP libvirtd_domain_state_reason_info reason for entering the current state of the domain
+# TYPE libvirtd_domain_state_reason_info gauge
+libvirtd_domain_state_reason_info{reason="ioerror",state="paused",uuid="` + testUUID + `"} 1
+`,
+		},
+	}, func(c *DomainStatsCollector, stat DomainStats, ch chan<- prometheus.Metric) {
+		c.collectState(testDomain, stat, ch)
+	})
+}
+
+func TestDomainStateReasonName(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    libvirt.DomainState
+		reason   int
+		expected string
+	}{
+		{"running/migrated", libvirt.DOMAIN_RUNNING, int(libvirt.DOMAIN_RUNNING_MIGRATED), "migrated"},
+		{"shutoff/migrated", libvirt.DOMAIN_SHUTOFF, int(libvirt.DOMAIN_SHUTOFF_MIGRATED), "migrated"},
+		{"paused/ioerror", libvirt.DOMAIN_PAUSED, int(libvirt.DOMAIN_PAUSED_IOERROR), "ioerror"},
+		{"crashed/panicked", libvirt.DOMAIN_CRASHED, int(libvirt.DOMAIN_CRASHED_PANICKED), "panicked"},
+		{"crashed/unknown", libvirt.DOMAIN_CRASHED, 42, "42"},
+		{"unknown/unknown", libvirt.DomainState(42), 0, "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := domainStateReasonName(tt.state, tt.reason); got != tt.expected {
+				t.Errorf("domainStateReasonName(%d, %d) = %q, want %q", tt.state, tt.reason, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCollectCPU(t *testing.T) {
+	runDomainStatsTests(t, []domainStatsTest{
+		{
+			name: "cpu",
+			stats: libvirt.DomainStats{
+				Cpu: &libvirt.DomainStatsCPU{
+					Time:   3000000000,
+					User:   2000000000,
+					System: 1000000000,
+					CacheMonitors: []libvirt.DomainStatsCPUCacheMonitor{
+						{
+							Name:  "vcpus_0-1",
+							Vcpus: "0-1",
+							Banks: []libvirt.DomainStatsCPUCacheMonitorBank{
+								{IDSet: true, ID: 0, BytesSet: true, Bytes: 5242880},
+								{IDSet: true, ID: 1},
+							},
+						},
+					},
+				},
+			},
+			expected: `
+# HELP libvirtd_domain_cpu_cache_monitor_bytes last level cache occupancy of the vcpus of the cache monitor in bytes
+# TYPE libvirtd_domain_cpu_cache_monitor_bytes gauge
+libvirtd_domain_cpu_cache_monitor_bytes{bank="0",monitor="vcpus_0-1",uuid="` + testUUID + `",vcpus="0-1"} 5.24288e+06
+# HELP libvirtd_domain_cpu_system system cpu time spent in nanoseconds
+# TYPE libvirtd_domain_cpu_system counter
+libvirtd_domain_cpu_system{uuid="` + testUUID + `"} 1e+09
+# HELP libvirtd_domain_cpu_time total cpu time spent for this domain in nanoseconds
+# TYPE libvirtd_domain_cpu_time counter
+libvirtd_domain_cpu_time{uuid="` + testUUID + `"} 3e+09
+# HELP libvirtd_domain_cpu_user user cpu time spent in nanoseconds
+# TYPE libvirtd_domain_cpu_user counter
+libvirtd_domain_cpu_user{uuid="` + testUUID + `"} 2e+09
+`,
+		},
+		{
+			name:     "missing",
+			stats:    libvirt.DomainStats{},
+			expected: ``,
+		},
+	}, func(c *DomainStatsCollector, stat DomainStats, ch chan<- prometheus.Metric) {
+		c.collectCPU(testDomain, stat, ch)
+	})
+}
+
+func TestCollectBalloon(t *testing.T) {
+	runDomainStatsTests(t, []domainStatsTest{
+		{
+			name: "no guest stats",
+			stats: libvirt.DomainStats{
+				Balloon: &libvirt.DomainStatsBalloon{
+					Current: 2097152,
+					Maximum: 4194304,
+				},
+			},
+			expected: `
+# HELP libvirtd_domain_balloon_current the memory in kiB currently used
+# TYPE libvirtd_domain_balloon_current gauge
+libvirtd_domain_balloon_current{uuid="` + testUUID + `"} 2.097152e+06
+# HELP libvirtd_domain_balloon_maximum the maximum memory in kiB allowed
+# TYPE libvirtd_domain_balloon_maximum gauge
+libvirtd_domain_balloon_maximum{uuid="` + testUUID + `"} 4.194304e+06
+`,
+		},
+		{
+			name: "guest stats",
+			stats: libvirt.DomainStats{
+				Balloon: &libvirt.DomainStatsBalloon{
+					Current:           2097152,
+					Maximum:           2097152,
+					SwapInSet:         true,
+					SwapIn:            1,
+					SwapOutSet:        true,
+					SwapOut:           2,
+					MajorFaultSet:     true,
+					MajorFault:        3,
+					MinorFaultSet:     true,
+					MinorFault:        4,
+					UnusedSet:         true,
+					Unused:            5,
+					AvailableSet:      true,
+					Available:         6,
+					RssSet:            true,
+					Rss:               7,
+					UsableSet:         true,
+					Usable:            8,
+					DiskCachesSet:     true,
+					DiskCaches:        9,
+					HugetlbPgAllocSet: true,
+					HugetlbPgAlloc:    10,
+					HugetlbPgFailSet:  true,
+					HugetlbPgFail:     11,
+				},
+			},
+			expected: `
+# HELP libvirtd_domain_balloon_available KiB of memory usable by the domain
+# TYPE libvirtd_domain_balloon_available gauge
+libvirtd_domain_balloon_available{uuid="` + testUUID + `"} 6
+# HELP libvirtd_domain_balloon_current the memory in kiB currently used
+# TYPE libvirtd_domain_balloon_current gauge
+libvirtd_domain_balloon_current{uuid="` + testUUID + `"} 2.097152e+06
+# HELP libvirtd_domain_balloon_disk_caches KiB of memory used by disk caches
+# TYPE libvirtd_domain_balloon_disk_caches gauge
+libvirtd_domain_balloon_disk_caches{uuid="` + testUUID + `"} 9
+# HELP libvirtd_domain_balloon_hugetlb_pgalloc number of successful huge page allocations done by virtio balloon
+# TYPE libvirtd_domain_balloon_hugetlb_pgalloc counter
+libvirtd_domain_balloon_hugetlb_pgalloc{uuid="` + testUUID + `"} 10
+# HELP libvirtd_domain_balloon_hugetlb_pgfail number of failed huge page allocations done by virtio balloon
+# TYPE libvirtd_domain_balloon_hugetlb_pgfail counter
+libvirtd_domain_balloon_hugetlb_pgfail{uuid="` + testUUID + `"} 11
+# HELP libvirtd_domain_balloon_major_fault number of page faults where disk I/O was required
+# TYPE libvirtd_domain_balloon_major_fault counter
+libvirtd_domain_balloon_major_fault{uuid="` + testUUID + `"} 3
+# HELP libvirtd_domain_balloon_maximum the maximum memory in kiB allowed
+# TYPE libvirtd_domain_balloon_maximum gauge
+libvirtd_domain_balloon_maximum{uuid="` + testUUID + `"} 2.097152e+06
+# HELP libvirtd_domain_balloon_minor_fault number of page faults where disk I/O was not required
+# TYPE libvirtd_domain_balloon_minor_fault counter
+libvirtd_domain_balloon_minor_fault{uuid="` + testUUID + `"} 4
+# HELP libvirtd_domain_balloon_rss resident set size of domain in KiB
+# TYPE libvirtd_domain_balloon_rss gauge
+libvirtd_domain_balloon_rss{uuid="` + testUUID + `"} 7
+# HELP libvirtd_domain_balloon_swap_in kiB of memory swapped in
+# TYPE libvirtd_domain_balloon_swap_in counter
+libvirtd_domain_balloon_swap_in{uuid="` + testUUID + `"} 1
+# HELP libvirtd_domain_balloon_swap_out kiB of memory swapped out
+# TYPE libvirtd_domain_balloon_swap_out counter
+libvirtd_domain_balloon_swap_out{uuid="` + testUUID + `"} 2
+# HELP libvirtd_domain_balloon_unused KiB of memory left unused by the system
+# TYPE libvirtd_domain_balloon_unused gauge
+libvirtd_domain_balloon_unused{uuid="` + testUUID + `"} 5
+# HELP libvirtd_domain_balloon_usable KiB of memory that can be reclaimed without swapping
+# TYPE libvirtd_domain_balloon_usable gauge
+libvirtd_domain_balloon_usable{uuid="` + testUUID + `"} 8
+`,
+		},
+	}, func(c *DomainStatsCollector, stat DomainStats, ch chan<- prometheus.Metric) {
+		c.collectBalloon(testDomain, stat, ch)
+	})
+}
+
+func TestCollectVcpu(t *testing.T) {
+	runDomainStatsTests(t, []domainStatsTest{
+		{
+			name: "two vcpus",
+			stats: libvirt.DomainStats{
+				Vcpu: []libvirt.DomainStatsVcpu{
+					{StateSet: true, State: libvirt.VCPU_RUNNING, TimeSet: true, Time: 1000},
+					{StateSet: true, State: libvirt.VCPU_OFFLINE, TimeSet: true, Time: 2000},
+				},
+			},
+			expected: `
+# HELP libvirtd_domain_vcpu_state state of the virtual CPU (virVcpuState enum)
+# TYPE libvirtd_domain_vcpu_state gauge
+libvirtd_domain_vcpu_state{uuid="` + testUUID + `",vcpu="0"} 1
+libvirtd_domain_vcpu_state{uuid="` + testUUID + `",vcpu="1"} 0
+# HELP libvirtd_domain_vcpu_time virtual cpu time spent
+# TYPE libvirtd_domain_vcpu_time counter
+libvirtd_domain_vcpu_time{uuid="` + testUUID + `",vcpu="0"} 1000
+libvirtd_domain_vcpu_time{uuid="` + testUUID + `",vcpu="1"} 2000
+`,
+		},
+		{
+			name: "wait, delay and halted",
+			stats: libvirt.DomainStats{
+				Vcpu: []libvirt.DomainStatsVcpu{
+					{
+						StateSet: true, State: libvirt.VCPU_RUNNING, TimeSet: true, Time: 1000,
+						WaitSet: true, Wait: 500000000, DelaySet: true, Delay: 1000000000, HaltedSet: true, Halted: true,
+					},
+					{
+						StateSet: true, State: libvirt.VCPU_RUNNING, TimeSet: true, Time: 2000,
+						WaitSet: true, Wait: 1000000000, DelaySet: true, Delay: 2000000000, HaltedSet: true, Halted: false,
+					},
+				},
+			},
+			expected: `
+# HELP libvirtd_domain_cpu_delay_seconds_total total time the virtual cpus of this domain spent runnable but waiting to run on a host cpu in seconds
+# TYPE libvirtd_domain_cpu_delay_seconds_total counter
+libvirtd_domain_cpu_delay_seconds_total{uuid="` + testUUID + `"} 3
+# HELP libvirtd_domain_vcpu_delay_seconds_total virtual cpu time spent runnable but waiting to run on a host cpu in seconds
+# TYPE libvirtd_domain_vcpu_delay_seconds_total counter
+libvirtd_domain_vcpu_delay_seconds_total{uuid="` + testUUID + `",vcpu="0"} 1
+libvirtd_domain_vcpu_delay_seconds_total{uuid="` + testUUID + `",vcpu="1"} 2
+# HELP libvirtd_domain_vcpu_halted whether the virtual CPU is halted
+# TYPE libvirtd_domain_vcpu_halted gauge
+libvirtd_domain_vcpu_halted{uuid="` + testUUID + `",vcpu="0"} 1
+libvirtd_domain_vcpu_halted{uuid="` + testUUID + `",vcpu="1"} 0
+# HELP libvirtd_domain_vcpu_state state of the virtual CPU (virVcpuState enum)
+# TYPE libvirtd_domain_vcpu_state gauge
+libvirtd_domain_vcpu_state{uuid="` + testUUID + `",vcpu="0"} 1
+libvirtd_domain_vcpu_state{uuid="` + testUUID + `",vcpu="1"} 1
+# HELP libvirtd_domain_vcpu_time virtual cpu time spent
+# TYPE libvirtd_domain_vcpu_time counter
+libvirtd_domain_vcpu_time{uuid="` + testUUID + `",vcpu="0"} 1000
+libvirtd_domain_vcpu_time{uuid="` + testUUID + `",vcpu="1"} 2000
+# HELP libvirtd_domain_vcpu_wait_seconds_total virtual cpu time spent waiting on I/O in seconds
+# TYPE libvirtd_domain_vcpu_wait_seconds_total counter
+libvirtd_domain_vcpu_wait_seconds_total{uuid="` + testUUID + `",vcpu="0"} 0.5
+libvirtd_domain_vcpu_wait_seconds_total{uuid="` + testUUID + `",vcpu="1"} 1
+`,
+		},
+	}, func(c *DomainStatsCollector, stat DomainStats, ch chan<- prometheus.Metric) {
+		c.collectVcpu(testDomain, stat, ch)
+	})
+}
+
+func TestCollectNet(t *testing.T) {
+	runDomainStatsTests(t, []domainStatsTest{
+		{
+			name: "tap device",
+			stats: libvirt.DomainStats{
+				Net: []libvirt.DomainStatsNet{
+					{
+						NameSet: true,
+						Name:    "tap0",
+						RxBytes: 1,
+						RxPkts:  2,
+						RxErrs:  3,
+						RxDrop:  4,
+						TxBytes: 5,
+						TxPkts:  6,
+						TxErrs:  7,
+						TxDrop:  8,
+					},
+				},
+			},
+			expected: `
+# HELP libvirtd_domain_net_rx_bytes bytes received
+# TYPE libvirtd_domain_net_rx_bytes counter
+libvirtd_domain_net_rx_bytes{interface="tap0",uuid="` + testUUID + `"} 1
+# HELP libvirtd_domain_net_rx_drop receive packets dropped
+# TYPE libvirtd_domain_net_rx_drop counter
+libvirtd_domain_net_rx_drop{interface="tap0",uuid="` + testUUID + `"} 4
+# HELP libvirtd_domain_net_rx_errors receive errors
+# TYPE libvirtd_domain_net_rx_errors counter
+libvirtd_domain_net_rx_errors{interface="tap0",uuid="` + testUUID + `"} 3
+# HELP libvirtd_domain_net_rx_packets packets received
+# TYPE libvirtd_domain_net_rx_packets counter
+libvirtd_domain_net_rx_packets{interface="tap0",uuid="` + testUUID + `"} 2
+# HELP libvirtd_domain_net_tx_bytes bytes transmitted
+# TYPE libvirtd_domain_net_tx_bytes counter
+libvirtd_domain_net_tx_bytes{interface="tap0",uuid="` + testUUID + `"} 5
+# HELP libvirtd_domain_net_tx_drop transmit packets dropped
+# TYPE libvirtd_domain_net_tx_drop gauge
+libvirtd_domain_net_tx_drop{interface="tap0",uuid="` + testUUID + `"} 8
+# HELP libvirtd_domain_net_tx_errors transmission errors
+# TYPE libvirtd_domain_net_tx_errors counter
+libvirtd_domain_net_tx_errors{interface="tap0",uuid="` + testUUID + `"} 7
+# HELP libvirtd_domain_net_tx_packets packets transmitted
+# TYPE libvirtd_domain_net_tx_packets counter
+libvirtd_domain_net_tx_packets{interface="tap0",uuid="` + testUUID + `"} 6
+`,
+		},
+	}, func(c *DomainStatsCollector, stat DomainStats, ch chan<- prometheus.Metric) {
+		c.collectNet(testDomain, stat, ch)
+	})
+}
+
+func TestCollectNetInfo(t *testing.T) {
+	domainXML := parseTestDomainXML(t)
+
+	runDomainStatsTests(t, []domainStatsTest{
+		{
+			name: "interfaces",
+			expected: `
+# HELP libvirtd_domain_interface_info information about the network interface from the domain definition
+# TYPE libvirtd_domain_interface_info gauge
+libvirtd_domain_interface_info{interface="tap3f2a1b0c",interface_id="3f2a1b0c-port",mac="fa:16:3e:00:00:01",model="virtio",source_bridge="br-int",source_network="",source_portgroup="",uuid="` + testUUID + `",vlan=""} 1
+libvirtd_domain_interface_info{interface="vnet1",interface_id="",mac="52:54:00:00:00:02",model="e1000",source_bridge="",source_network="default",source_portgroup="engineering",uuid="` + testUUID + `",vlan="42,47"} 1
+`,
+		},
+	}, func(c *DomainStatsCollector, _ DomainStats, ch chan<- prometheus.Metric) {
+		c.collectNetInfo(testDomain, domainXML, ch)
+	})
+}
+
+func TestCollectBlock(t *testing.T) {
+	runDomainStatsTests(t, []domainStatsTest{
+		{
+			name: "disk",
+			stats: libvirt.DomainStats{
+				Block: []libvirt.DomainStatsBlock{
+					{
+						NameSet:    true,
+						Name:       "vda",
+						PathSet:    true,
+						Path:       "/var/lib/nova/instances/disk",
+						RdReqs:     1,
+						RdBytes:    2,
+						RdTimes:    3,
+						WrReqs:     4,
+						WrBytes:    5,
+						WrTimes:    6,
+						FlReqs:     7,
+						FlTimes:    8,
+						Allocation: 9,
+						Capacity:   10,
+						Physical:   11,
+					},
+				},
+			},
+			expected: `
+# HELP libvirtd_domain_block_allocation offset of the highest written sector
+# TYPE libvirtd_domain_block_allocation gauge
+libvirtd_domain_block_allocation{device="vda",path="/var/lib/nova/instances/disk",uuid="` + testUUID + `"} 9
+# HELP libvirtd_domain_block_capacity logical size in bytes of the block device backing image
+# TYPE libvirtd_domain_block_capacity gauge
+libvirtd_domain_block_capacity{device="vda",path="/var/lib/nova/instances/disk",uuid="` + testUUID + `"} 10
+# HELP libvirtd_domain_block_flush_requests total flush requests
+# TYPE libvirtd_domain_block_flush_requests counter
+libvirtd_domain_block_flush_requests{device="vda",path="/var/lib/nova/instances/disk",uuid="` + testUUID + `"} 7
+# HELP libvirtd_domain_block_flush_times total time (ns) spent on cache flushing
+# TYPE libvirtd_domain_block_flush_times counter
+libvirtd_domain_block_flush_times{device="vda",path="/var/lib/nova/instances/disk",uuid="` + testUUID + `"} 8
+# HELP libvirtd_domain_block_physical physical size in bytes of the container of the backing image
+# TYPE libvirtd_domain_block_physical gauge
+libvirtd_domain_block_physical{device="vda",path="/var/lib/nova/instances/disk",uuid="` + testUUID + `"} 11
+# HELP libvirtd_domain_block_read_bytes number of read bytes
+# TYPE libvirtd_domain_block_read_bytes counter
+libvirtd_domain_block_read_bytes{device="vda",path="/var/lib/nova/instances/disk",uuid="` + testUUID + `"} 2
+# HELP libvirtd_domain_block_read_requests number of read requests
+# TYPE libvirtd_domain_block_read_requests counter
+libvirtd_domain_block_read_requests{device="vda",path="/var/lib/nova/instances/disk",uuid="` + testUUID + `"} 1
+# HELP libvirtd_domain_block_read_times total time (ns) spent on reads
+# TYPE libvirtd_domain_block_read_times counter
+libvirtd_domain_block_read_times{device="vda",path="/var/lib/nova/instances/disk",uuid="` + testUUID + `"} 3
+# HELP libvirtd_domain_block_write_bytes number of written bytes
+# TYPE libvirtd_domain_block_write_bytes counter
+libvirtd_domain_block_write_bytes{device="vda",path="/var/lib/nova/instances/disk",uuid="` + testUUID + `"} 5
+# HELP libvirtd_domain_block_write_requests number of written requests
+# TYPE libvirtd_domain_block_write_requests counter
+libvirtd_domain_block_write_requests{device="vda",path="/var/lib/nova/instances/disk",uuid="` + testUUID + `"} 4
+# HELP libvirtd_domain_block_write_times total time (ns) spent on writes
+# TYPE libvirtd_domain_block_write_times counter
+libvirtd_domain_block_write_times{device="vda",path="/var/lib/nova/instances/disk",uuid="` + testUUID + `"} 6
+`,
+		},
+	}, func(c *DomainStatsCollector, stat DomainStats, ch chan<- prometheus.Metric) {
+		c.collectBlock(testDomain, stat, ch)
+	})
+}
+
+func TestCollectBlockBacking(t *testing.T) {
+	conn := &fakeConnect{}
+
+	opts := DefaultOptions()
+	opts.DomainBlockBacking = true
+
+	c := newTestDomainStatsCollector(conn, opts)
+	stat := testDomainStats(libvirt.DomainStats{
+		Block: []libvirt.DomainStatsBlock{
+			{
+				NameSet:       true,
+				Name:          "vda",
+				PathSet:       true,
+				Path:          "/var/lib/nova/instances/disk",
+				AllocationSet: true,
+				Allocation:    1,
+			},
+			{
+				NameSet:         true,
+				Name:            "vda",
+				BackingIndexSet: true,
+				BackingIndex:    1,
+				PathSet:         true,
+				Path:            "/var/lib/nova/instances/_base/image",
+				AllocationSet:   true,
+				Allocation:      2,
+			},
+		},
+	})
+
+	expected := `
+# HELP libvirtd_domain_block_allocation offset of the highest written sector
+# TYPE libvirtd_domain_block_allocation gauge
+libvirtd_domain_block_allocation{backing_index="",device="vda",path="/var/lib/nova/instances/disk",uuid="` + testUUID + `"} 1
+libvirtd_domain_block_allocation{backing_index="1",device="vda",path="/var/lib/nova/instances/_base/image",uuid="` + testUUID + `"} 2
+`
+
+	err := testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.collectBlock(testDomain, stat, ch)
+	}), strings.NewReader(expected), "libvirtd_domain_block_allocation")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.CollectAndCount(c)
+
+	if conn.statsFlags&libvirt.CONNECT_GET_ALL_DOMAINS_STATS_BACKING == 0 {
+		t.Errorf("requested stats with flags %#x, want the backing chain", conn.statsFlags)
+	}
+}
+
+func TestCollectBlockErrors(t *testing.T) {
+	c := newTestDomainStatsCollector(&fakeConnect{}, DefaultOptions())
+	stat := DomainStats{
+		DomainStats: libvirt.DomainStats{
+			State:   &libvirt.DomainStatsState{State: libvirt.DOMAIN_PAUSED},
+			Balloon: &libvirt.DomainStatsBalloon{RssSet: true, Rss: 1024},
+			Block:   []libvirt.DomainStatsBlock{{Name: "vda", RdReqsSet: true}, {Name: "vdb", RdReqsSet: true}},
+		},
+		Domain: &fakeDomain{
+			uuid: testUUID,
+			diskErrors: []libvirt.DomainDiskError{
+				{Disk: "vdb", Error: libvirt.DOMAIN_DISK_ERROR_NO_SPACE},
+			},
+		},
+	}
+
+	expected := `
+# HELP libvirtd_domain_block_errors whether the block device hit the given I/O error, such as running out of space
+# TYPE libvirtd_domain_block_errors gauge
+libvirtd_domain_block_errors{device="vda",error="no_space",uuid="` + testUUID + `"} 0
+libvirtd_domain_block_errors{device="vda",error="unspecified",uuid="` + testUUID + `"} 0
+libvirtd_domain_block_errors{device="vdb",error="no_space",uuid="` + testUUID + `"} 1
+libvirtd_domain_block_errors{device="vdb",error="unspecified",uuid="` + testUUID + `"} 0
+`
+
+	err := testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.collectBlockErrors(testDomain, stat, ch)
+	}), strings.NewReader(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDomainStatsCollectorBlockErrorsBusy(t *testing.T) {
+	tests := []struct {
+		name    string
+		block   []libvirt.DomainStatsBlock
+		enabled bool
+		calls   int
+	}{
+		{"complete", []libvirt.DomainStatsBlock{{Name: "vda", RdReqsSet: true, RdReqs: 1}}, true, 1},
+		{"incomplete", []libvirt.DomainStatsBlock{{Name: "vda"}}, true, 0},
+		{"disabled", []libvirt.DomainStatsBlock{{Name: "vda", RdReqsSet: true, RdReqs: 1}}, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain := &fakeDomain{uuid: testUUID, name: testDomain.name}
+			conn := &fakeConnect{
+				stats: []DomainStats{
+					{
+						DomainStats: libvirt.DomainStats{
+							State:   &libvirt.DomainStatsState{State: libvirt.DOMAIN_RUNNING},
+							Balloon: &libvirt.DomainStatsBalloon{RssSet: true, Rss: 1024},
+							Block:   tt.block,
+						},
+						Domain: domain,
+					},
+				},
+			}
+
+			opts := DefaultOptions()
+			opts.Collectors["domain_stats.block_errors"] = tt.enabled
+
+			c := newTestDomainStatsCollector(conn, opts)
+			testutil.CollectAndCount(c)
+
+			if domain.diskErrorCalls != tt.calls {
+				t.Errorf("got the disk errors %d times, want %d", domain.diskErrorCalls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestCollectBlockThreshold(t *testing.T) {
+	c := newTestDomainStatsCollector(&fakeConnect{}, DefaultOptions())
+	stat := testDomainStats(libvirt.DomainStats{
+		Block: []libvirt.DomainStatsBlock{
+			{Name: "vda", Path: "/dev/vg/disk", ThresholdSet: true, Threshold: 1073741824},
+			{Name: "vdb", Path: "/dev/vg/data"},
+		},
+	})
+
+	expected := `
+# HELP libvirtd_domain_block_threshold_bytes write threshold of the block device in bytes, an event is raised once its allocation crosses it
+# TYPE libvirtd_domain_block_threshold_bytes gauge
+libvirtd_domain_block_threshold_bytes{device="vda",path="/dev/vg/disk",uuid="` + testUUID + `"} 1.073741824e+09
+`
+
+	err := testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.collectBlock(testDomain, stat, ch)
+	}), strings.NewReader(expected), "libvirtd_domain_block_threshold_bytes")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCollectBlockInfo(t *testing.T) {
+	domainXML := parseTestDomainXML(t)
+
+	runDomainStatsTests(t, []domainStatsTest{
+		{
+			name: "disks",
+			expected: `
+# HELP libvirtd_domain_block_info information about the block device from the domain definition
+# TYPE libvirtd_domain_block_info gauge
+libvirtd_domain_block_info{bus="virtio",cache="none",device="vda",discard="unmap",format="qcow2",io="native",iothread="",serial="6c9a6a04",uuid="` + testUUID + `"} 1
+libvirtd_domain_block_info{bus="virtio",cache="writeback",device="vdb",discard="",format="raw",io="",iothread="1",serial="",uuid="` + testUUID + `"} 1
+`,
+		},
+	}, func(c *DomainStatsCollector, _ DomainStats, ch chan<- prometheus.Metric) {
+		c.collectBlockInfo(testDomain, domainXML, ch)
+	})
+}
+
+func TestCollectPerf(t *testing.T) {
+	runDomainStatsTests(t, []domainStatsTest{
+		{
+			name: "enabled events",
+			stats: libvirt.DomainStats{
+				Perf: &libvirt.DomainStatsPerf{
+					CmtSet:          true,
+					Cmt:             1048576,
+					CacheMissesSet:  true,
+					CacheMisses:     2,
+					CpuCyclesSet:    true,
+					CpuCycles:       3,
+					TaskClockSet:    true,
+					TaskClock:       4000000000,
+					ContextSwitches: 5,
+				},
+			},
+			expected: `
+# HELP libvirtd_domain_perf_cache_misses_total number of cache misses
+# TYPE libvirtd_domain_perf_cache_misses_total counter
+libvirtd_domain_perf_cache_misses_total{uuid="` + testUUID + `"} 2
+# HELP libvirtd_domain_perf_cmt_bytes usage of the last level cache in bytes
+# TYPE libvirtd_domain_perf_cmt_bytes gauge
+libvirtd_domain_perf_cmt_bytes{uuid="` + testUUID + `"} 1.048576e+06
+# HELP libvirtd_domain_perf_cpu_cycles_total number of cpu cycles
+# TYPE libvirtd_domain_perf_cpu_cycles_total counter
+libvirtd_domain_perf_cpu_cycles_total{uuid="` + testUUID + `"} 3
+# HELP libvirtd_domain_perf_task_clock_seconds_total task clock time in seconds
+# TYPE libvirtd_domain_perf_task_clock_seconds_total counter
+libvirtd_domain_perf_task_clock_seconds_total{uuid="` + testUUID + `"} 4
+`,
+		},
+		{
+			name:     "no events",
+			stats:    libvirt.DomainStats{Perf: &libvirt.DomainStatsPerf{}},
+			expected: ``,
+		},
+	}, func(c *DomainStatsCollector, stat DomainStats, ch chan<- prometheus.Metric) {
+		c.collectPerf(testDomain, stat, ch)
+	})
+}
+
+func TestCollectMemory(t *testing.T) {
+	runDomainStatsTests(t, []domainStatsTest{
+		{
+			name: "bandwidth",
+			stats: libvirt.DomainStats{
+				Memory: &libvirt.DomainStatsMemory{
+					BandwidthMonitor: []libvirt.DomainStatsMemoryBandwidthMonitor{
+						{
+							Name:  "vcpus_0-1",
+							VCPUs: "0-1",
+							Nodes: []libvirt.DomainStatsMemoryBandwidthMonitorNode{
+								{IDSet: true, ID: 0, BytesLocalSet: true, BytesLocal: 1024, BytesTotalSet: true, BytesTotal: 4096},
+							},
+						},
+					},
+				},
+			},
+			expected: `
+# HELP libvirtd_domain_memory_bandwidth_bytes_total bytes of memory bandwidth used by the vcpus of the monitor on all memory controllers of the node
+# TYPE libvirtd_domain_memory_bandwidth_bytes_total counter
+libvirtd_domain_memory_bandwidth_bytes_total{monitor="vcpus_0-1",node="0",uuid="` + testUUID + `",vcpus="0-1"} 4096
+# HELP libvirtd_domain_memory_bandwidth_local_bytes_total bytes of memory bandwidth used by the vcpus of the monitor on the memory controller of their host cpu
+# TYPE libvirtd_domain_memory_bandwidth_local_bytes_total counter
+libvirtd_domain_memory_bandwidth_local_bytes_total{monitor="vcpus_0-1",node="0",uuid="` + testUUID + `",vcpus="0-1"} 1024
+`,
+		},
+		{
+			name:     "no resctrl",
+			stats:    libvirt.DomainStats{Memory: &libvirt.DomainStatsMemory{}},
+			expected: ``,
+		},
+	}, func(c *DomainStatsCollector, stat DomainStats, ch chan<- prometheus.Metric) {
+		c.collectMemory(testDomain, stat, ch)
+	})
+}
+
+func TestCollectDirtyRate(t *testing.T) {
+	runDomainStatsTests(t, []domainStatsTest{
+		{
+			name: "measured",
+			stats: libvirt.DomainStats{
+				DirtyRate: &libvirt.DomainStatsDirtyRate{
+					CalcStatusSet:         true,
+					CalcStatus:            int(libvirt.DOMAIN_DIRTYRATE_MEASURED),
+					CalcStartTimeSet:      true,
+					CalcStartTime:         348123,
+					CalcPeriodSet:         true,
+					CalcPeriod:            1,
+					MegabytesPerSecondSet: true,
+					MegabytesPerSecond:    8,
+					VCPUS: []libvirt.DomainStatsDirtyRateVCPU{
+						{MegabytesPerSecondSet: true, MegabytesPerSecond: 6},
+						{MegabytesPerSecondSet: true, MegabytesPerSecond: 2},
+					},
+				},
+			},
+			expected: `
+# HELP libvirtd_domain_dirtyrate_bytes_per_second rate the domain dirtied memory at during the last calculation in bytes per second
+# TYPE libvirtd_domain_dirtyrate_bytes_per_second gauge
+libvirtd_domain_dirtyrate_bytes_per_second{uuid="` + testUUID + `"} 8.388608e+06
+# HELP libvirtd_domain_dirtyrate_calc_period_seconds period of the last dirty rate calculation of the domain in seconds
+# TYPE libvirtd_domain_dirtyrate_calc_period_seconds gauge
+libvirtd_domain_dirtyrate_calc_period_seconds{uuid="` + testUUID + `"} 1
+# HELP libvirtd_domain_dirtyrate_calc_start_time_seconds start time of the last dirty rate calculation of the domain on the monotonic clock of the hypervisor
+# TYPE libvirtd_domain_dirtyrate_calc_start_time_seconds gauge
+libvirtd_domain_dirtyrate_calc_start_time_seconds{uuid="` + testUUID + `"} 348123
+# HELP libvirtd_domain_dirtyrate_calc_status whether the last dirty rate calculation of the domain is in the given status
+# TYPE libvirtd_domain_dirtyrate_calc_status gauge
+libvirtd_domain_dirtyrate_calc_status{status="measured",uuid="` + testUUID + `"} 1
+libvirtd_domain_dirtyrate_calc_status{status="measuring",uuid="` + testUUID + `"} 0
+libvirtd_domain_dirtyrate_calc_status{status="unstarted",uuid="` + testUUID + `"} 0
+# HELP libvirtd_domain_dirtyrate_vcpu_bytes_per_second rate the vcpu dirtied memory at during the last calculation in bytes per second
+# TYPE libvirtd_domain_dirtyrate_vcpu_bytes_per_second gauge
+libvirtd_domain_dirtyrate_vcpu_bytes_per_second{uuid="` + testUUID + `",vcpu="0"} 6.291456e+06
+libvirtd_domain_dirtyrate_vcpu_bytes_per_second{uuid="` + testUUID + `",vcpu="1"} 2.097152e+06
+`,
+		},
+	}, func(c *DomainStatsCollector, stat DomainStats, ch chan<- prometheus.Metric) {
+		c.collectDirtyRate(testDomain, stat, ch)
+	})
+}
+
+func TestCollectVM(t *testing.T) {
+	exits := uint64(4096)
+	haltPollNs := uint64(1500000000)
+	haltPollMax := uint64(200)
+	pages := uint64(512)
+	name := "unsupported"
+
+	runDomainStatsTests(t, []domainStatsTest{
+		{
+			name: "stats",
+			stats: libvirt.DomainStats{
+				VM: []libvirt.TypedParamValue{
+					{Name: "remote_tlb_flush.sum", ULong: &exits},
+					{Name: "pages_4k.cur", ULong: &pages},
+					{Name: "max_mmu_page_hash_collisions.max", ULong: &haltPollMax},
+					{Name: "unknown.type", ULong: &exits},
+					{Name: "name.cur", String: &name},
+				},
+				Vcpu: []libvirt.DomainStatsVcpu{
+					{
+						Custom: []libvirt.TypedParamValue{
+							{Name: "exits.sum", ULong: &exits},
+							{Name: "halt_poll_success_ns.sum", ULong: &haltPollNs},
+						},
+					},
+				},
+			},
+			expected: `
+# HELP libvirtd_domain_vm_max_mmu_page_hash_collisions_peak hypervisor statistic max_mmu_page_hash_collisions.max
+# TYPE libvirtd_domain_vm_max_mmu_page_hash_collisions_peak gauge
+libvirtd_domain_vm_max_mmu_page_hash_collisions_peak{uuid="` + testUUID + `"} 200
+# HELP libvirtd_domain_vm_pages_4k hypervisor statistic pages_4k.cur
+# TYPE libvirtd_domain_vm_pages_4k gauge
+libvirtd_domain_vm_pages_4k{uuid="` + testUUID + `"} 512
+# HELP libvirtd_domain_vm_remote_tlb_flush_total hypervisor statistic remote_tlb_flush.sum
+# TYPE libvirtd_domain_vm_remote_tlb_flush_total counter
+libvirtd_domain_vm_remote_tlb_flush_total{uuid="` + testUUID + `"} 4096
+# HELP libvirtd_domain_vm_vcpu_exits_total hypervisor statistic exits.sum
+# TYPE libvirtd_domain_vm_vcpu_exits_total counter
+libvirtd_domain_vm_vcpu_exits_total{uuid="` + testUUID + `",vcpu="0"} 4096
+# HELP libvirtd_domain_vm_vcpu_halt_poll_success_seconds_total hypervisor statistic halt_poll_success_ns.sum
+# TYPE libvirtd_domain_vm_vcpu_halt_poll_success_seconds_total counter
+libvirtd_domain_vm_vcpu_halt_poll_success_seconds_total{uuid="` + testUUID + `",vcpu="0"} 1.5
+`,
+		},
+	}, func(c *DomainStatsCollector, stat DomainStats, ch chan<- prometheus.Metric) {
+		c.collectVM(testDomain, stat, ch)
+	})
+}
+
+func TestDomainStatsCollectorDirtyRateCalc(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time {
+		return start
+	}
+	t.Cleanup(func() {
+		now = time.Now
+	})
+
+	running := &fakeDomain{uuid: testUUID, name: testDomain.name}
+	shutoff := &fakeDomain{uuid: "5e4d3c2b-db", name: "instance-00000002"}
+	failing := &fakeDomain{
+		uuid:         "9a8b7c6d-build",
+		name:         "ci-build-1",
+		dirtyRateErr: libvirt.Error{Code: libvirt.ERR_OPERATION_FAILED},
+	}
+	conn := &fakeConnect{
+		stats: []DomainStats{
+			{
+				DomainStats: libvirt.DomainStats{
+					State: &libvirt.DomainStatsState{State: libvirt.DOMAIN_RUNNING},
+				},
+				Domain: failing,
+			},
+			{
+				DomainStats: libvirt.DomainStats{
+					State: &libvirt.DomainStatsState{State: libvirt.DOMAIN_RUNNING},
+				},
+				Domain: running,
+			},
+			{
+				DomainStats: libvirt.DomainStats{
+					State: &libvirt.DomainStatsState{State: libvirt.DOMAIN_SHUTOFF},
+				},
+				Domain: shutoff,
+			},
+		},
+	}
+
+	opts := DefaultOptions()
+	opts.Collectors["domain_stats.dirtyrate"] = true
+	opts.DomainStatsNoWait = false
+	opts.DirtyRateCalcInterval = 5 * time.Minute
+	opts.DirtyRateCalcPeriod = 2 * time.Second
+
+	// NOTE: Collectors are created anew on every probe, the calculations
+	//       started are tracked by the connection.
+	connection := newFakeConnection(conn)
+	collect := func() {
+		testutil.CollectAndCount(NewDomainStatsCollector(promslog.NewNopLogger(), connection, opts))
+	}
+
+	collect()
+	now = func() time.Time {
+		return start.Add(time.Minute)
+	}
+	collect()
+	now = func() time.Time {
+		return start.Add(5 * time.Minute)
+	}
+	collect()
+
+	if !slices.Equal(running.dirtyRateCalcs, []int{2, 2}) {
+		t.Errorf("started calculations %v on the running domain, want [2 2]", running.dirtyRateCalcs)
+	}
+	if len(shutoff.dirtyRateCalcs) != 0 {
+		t.Errorf("started calculations %v on the shut off domain, want none", shutoff.dirtyRateCalcs)
+	}
+	if !slices.Equal(failing.dirtyRateCalcs, []int{2, 2, 2}) {
+		t.Errorf("started calculations %v on the failing domain, want them retried [2 2 2]", failing.dirtyRateCalcs)
+	}
+}
+
+func TestCollectIOThread(t *testing.T) {
+	runDomainStatsTests(t, []domainStatsTest{
+		{
+			name: "polling",
+			stats: libvirt.DomainStats{
+				IOThread: []libvirt.DomainStatsIOThread{
+					{},
+					{
+						PollMaxNSSet:  true,
+						PollMaxNS:     32768,
+						PollGrowSet:   true,
+						PollGrow:      2,
+						PollShrinkSet: true,
+						PollShrink64:  4,
+					},
+				},
+			},
+			expected: `
+# HELP libvirtd_domain_iothread_poll_grow factor the polling time of the iothread grows by, 0 for the hypervisor default
+# TYPE libvirtd_domain_iothread_poll_grow gauge
+libvirtd_domain_iothread_poll_grow{iothread="1",uuid="` + testUUID + `"} 2
+# HELP libvirtd_domain_iothread_poll_max_seconds maximum polling time of the iothread in seconds, 0 if polling is disabled
+# TYPE libvirtd_domain_iothread_poll_max_seconds gauge
+libvirtd_domain_iothread_poll_max_seconds{iothread="1",uuid="` + testUUID + `"} 3.2768e-05
+# HELP libvirtd_domain_iothread_poll_shrink divisor the polling time of the iothread shrinks by, 0 for the hypervisor default
+# TYPE libvirtd_domain_iothread_poll_shrink gauge
+libvirtd_domain_iothread_poll_shrink{iothread="1",uuid="` + testUUID + `"} 4
+`,
+		},
+	}, func(c *DomainStatsCollector, stat DomainStats, ch chan<- prometheus.Metric) {
+		c.collectIOThread(testDomain, stat, ch)
+	})
+}
+
+func TestCollectIOThreadInfo(t *testing.T) {
+	c := newTestDomainStatsCollector(&fakeConnect{}, DefaultOptions())
+	stat := DomainStats{
+		DomainStats: libvirt.DomainStats{
+			State:   &libvirt.DomainStatsState{State: libvirt.DOMAIN_RUNNING},
+			Balloon: &libvirt.DomainStatsBalloon{RssSet: true, Rss: 1024},
+		},
+		Domain: &fakeDomain{
+			uuid: testUUID,
+			ioThreads: []libvirt.DomainIOThreadInfo{
+				{IOThreadID: 1, CpuMap: []bool{true, true, true, true, false, false, true, false}},
+				{IOThreadID: 2, CpuMap: []bool{false, true}},
+			},
+		},
+	}
+
+	expected := `
+# HELP libvirtd_domain_iothread_info information about the iothread, with the host cpus it is pinned to
+# TYPE libvirtd_domain_iothread_info gauge
+libvirtd_domain_iothread_info{cpus="0-3,6",iothread="1",uuid="` + testUUID + `"} 1
+libvirtd_domain_iothread_info{cpus="1",iothread="2",uuid="` + testUUID + `"} 1
+`
+
+	err := testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+		c.collectIOThreadInfo(testDomain, stat, ch)
+	}), strings.NewReader(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDomainStatsCollectorIOThreadInfoCalls(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups map[string]bool
+		xml    string
+		calls  int
+	}{
+		{"disabled", map[string]bool{}, testDomainXML, 0},
+		{"enabled", map[string]bool{"domain_stats.iothread_info": true}, testDomainXML, 1},
+		{"with iothreads", map[string]bool{"domain_stats.iothread_info": true, "domain_stats.info": true}, testDomainXML, 1},
+		{"without iothreads", map[string]bool{"domain_stats.iothread_info": true, "domain_stats.info": true}, "<domain/>", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain := &fakeDomain{uuid: testUUID, name: testDomain.name, xml: tt.xml}
+			conn := &fakeConnect{
+				stats: []DomainStats{
+					{
+						DomainStats: libvirt.DomainStats{
+							State: &libvirt.DomainStatsState{State: libvirt.DOMAIN_RUNNING},
+						},
+						Domain: domain,
+					},
+				},
+			}
+
+			opts := DefaultOptions()
+			opts.DomainStatsNoWait = false
+			for name, enabled := range tt.groups {
+				opts.Collectors[name] = enabled
+			}
+
+			c := newTestDomainStatsCollector(conn, opts)
+			testutil.CollectAndCount(c)
+
+			if domain.ioThreadInfoCalls != tt.calls {
+				t.Errorf("got the iothreads %d times, want %d", domain.ioThreadInfoCalls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestDomainStatsCollectorStatsTypes(t *testing.T) {
+	conn := &fakeConnect{}
+
+	opts := DefaultOptions()
+	opts.Collectors["domain_stats.block"] = false
+	opts.Collectors["domain_stats.net"] = false
+
+	c := newTestDomainStatsCollector(conn, opts)
+	testutil.CollectAndCount(c)
+
+	expected := libvirt.DOMAIN_STATS_STATE | libvirt.DOMAIN_STATS_CPU_TOTAL |
+		libvirt.DOMAIN_STATS_BALLOON | libvirt.DOMAIN_STATS_VCPU | libvirt.DOMAIN_STATS_PERF |
+		libvirt.DOMAIN_STATS_IOTHREAD | libvirt.DOMAIN_STATS_MEMORY
+	if conn.statsTypes != expected {
+		t.Errorf("requested stats %#x, want %#x", conn.statsTypes, expected)
+	}
+}
+
+func TestDomainStatsCollectorNoGroups(t *testing.T) {
+	conn := &fakeConnect{
+		stats: []DomainStats{
+			testDomainStats(libvirt.DomainStats{
+				State: &libvirt.DomainStatsState{State: libvirt.DOMAIN_RUNNING},
+			}),
+		},
+	}
+
+	opts := DefaultOptions()
+	opts.Collectors["domain_stats.info"] = false
+	for _, group := range domainStatsGroups {
+		opts.Collectors["domain_stats."+group.name] = false
+	}
+
+	c := newTestDomainStatsCollector(conn, opts)
+
+	// NOTE: The state is still requested from libvirt, but not exported.
+	err := testutil.CollectAndCompare(c, strings.NewReader(""),
+		"libvirtd_domain_info",
+		"libvirtd_domain_state", "libvirtd_domain_state_reason_info",
+		"libvirtd_domain_domain_state", "libvirtd_domain_domain_state_reason",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conn.statsTypes != libvirt.DOMAIN_STATS_STATE {
+		t.Errorf("requested stats %#x, want only the state", conn.statsTypes)
+	}
+}
+
+func TestDomainStatsCollectorError(t *testing.T) {
+	conn := &fakeConnect{
+		statsErr: libvirt.Error{Code: libvirt.ERR_OPERATION_TIMEOUT},
+	}
+	connection := newFakeConnection(conn)
+	c := NewDomainStatsCollector(promslog.NewNopLogger(), connection, DefaultOptions())
+
+	expected := `
+# HELP libvirtd_scrape_success whether the collector scrape succeeded
+# TYPE libvirtd_scrape_success gauge
+libvirtd_scrape_success{collector="domain_stats"} 0
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected), "libvirtd_scrape_success")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := testutil.ToFloat64(connection.Errors.WithLabelValues("domain_stats", "68"))
+	if count != 1 {
+		t.Errorf("counted %v errors, want 1", count)
+	}
+}
+
+func TestCollectStatsIncomplete(t *testing.T) {
+	running := &libvirt.DomainStatsState{State: libvirt.DOMAIN_RUNNING}
+	complete := []libvirt.DomainStatsBlock{{Name: "vda", RdReqsSet: true, RdReqs: 1}}
+	skipped := []libvirt.DomainStatsBlock{{Name: "vda"}}
+
+	tests := []domainStatsTest{
+		{
+			name: "complete",
+			stats: libvirt.DomainStats{
+				State:   running,
+				Balloon: &libvirt.DomainStatsBalloon{RssSet: true, Rss: 1024},
+				Block:   complete,
+			},
+			expected: `
+# HELP libvirtd_domain_stats_incomplete whether the stats of the domain are incomplete because it was busy with a job
+# TYPE libvirtd_domain_stats_incomplete gauge
+libvirtd_domain_stats_incomplete{uuid="` + testUUID + `"} 0
+`,
+		},
+		{
+			name: "missing balloon",
+			stats: libvirt.DomainStats{
+				State:   running,
+				Balloon: &libvirt.DomainStatsBalloon{CurrentSet: true, Current: 1024},
+				Block:   complete,
+			},
+			expected: `
+# HELP libvirtd_domain_stats_incomplete whether the stats of the domain are incomplete because it was busy with a job
+# TYPE libvirtd_domain_stats_incomplete gauge
+libvirtd_domain_stats_incomplete{uuid="` + testUUID + `"} 1
+`,
+		},
+		{
+			name: "missing block",
+			stats: libvirt.DomainStats{
+				State:   running,
+				Balloon: &libvirt.DomainStatsBalloon{RssSet: true, Rss: 1024},
+				Block:   skipped,
+			},
+			expected: `
+# HELP libvirtd_domain_stats_incomplete whether the stats of the domain are incomplete because it was busy with a job
+# TYPE libvirtd_domain_stats_incomplete gauge
+libvirtd_domain_stats_incomplete{uuid="` + testUUID + `"} 1
+`,
+		},
+		{
+			name: "shutoff",
+			stats: libvirt.DomainStats{
+				State: &libvirt.DomainStatsState{State: libvirt.DOMAIN_SHUTOFF},
+				Block: skipped,
+			},
+			expected: `
+# HELP libvirtd_domain_stats_incomplete whether the stats of the domain are incomplete because it was busy with a job
+# TYPE libvirtd_domain_stats_incomplete gauge
+libvirtd_domain_stats_incomplete{uuid="` + testUUID + `"} 0
+`,
+		},
+	}
+
+	runDomainStatsTests(t, tests, func(c *DomainStatsCollector, stat DomainStats, ch chan<- prometheus.Metric) {
+		c.collectStatsIncomplete(testDomain, stat, ch)
+	})
+}
+
+func TestDomainStatsCollectorNoWait(t *testing.T) {
+	tests := []struct {
+		name        string
+		noWait      bool
+		unsupported bool
+		flags       libvirt.ConnectGetAllDomainStatsFlags
+	}{
+		{"enabled", true, false, libvirt.CONNECT_GET_ALL_DOMAINS_STATS_NOWAIT},
+		{"disabled", false, false, 0},
+		{"unsupported", true, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConnect{}
+			if tt.unsupported {
+				conn.unsupportedFlags = libvirt.CONNECT_GET_ALL_DOMAINS_STATS_NOWAIT
+			}
+
+			opts := DefaultOptions()
+			opts.Collectors["domain_stats.state"] = false
+			opts.DomainStatsNoWait = tt.noWait
+
+			c := newTestDomainStatsCollector(conn, opts)
+
+			err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP libvirtd_scrape_success whether the collector scrape succeeded
+# TYPE libvirtd_scrape_success gauge
+libvirtd_scrape_success{collector="domain_stats"} 1
+`), "libvirtd_scrape_success")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if conn.statsFlags != tt.flags {
+				t.Errorf("requested stats with flags %#x, want %#x", conn.statsFlags, tt.flags)
+			}
+
+			if conn.statsTypes&libvirt.DOMAIN_STATS_STATE == 0 {
+				t.Errorf("requested stats %#x, want the state of domains", conn.statsTypes)
+			}
+		})
+	}
+}
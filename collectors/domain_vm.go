@@ -0,0 +1,145 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"libvirt.org/go/libvirt"
+)
+
+// vmStatTypes maps the suffixes libvirt names the hypervisor statistics of
+// domains with, after the type of the QEMU statistic, to how they are
+// exported.
+//
+// NOTE: libvirt does not pass on the histograms of QEMU.
+var vmStatTypes = map[string]struct {
+	suffix    string
+	valueType prometheus.ValueType
+}{
+	"sum": {"_total", prometheus.CounterValue}, // cumulative
+	"cur": {"", prometheus.GaugeValue},         // instant
+	"max": {"_peak", prometheus.GaugeValue},    // peak
+}
+
+var invalidMetricNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// vmStat is a hypervisor statistic of a domain translated to a metric.
+type vmStat struct {
+	name      string
+	help      string
+	valueType prometheus.ValueType
+	value     float64
+}
+
+// newVMStat translates a hypervisor statistic, named <name>.<type> by
+// libvirt, to a metric under prefix in base units.
+func newVMStat(prefix string, param libvirt.TypedParamValue) (vmStat, bool) {
+	i := strings.LastIndex(param.Name, ".")
+	if i < 0 {
+		return vmStat{}, false
+	}
+
+	name, typ := param.Name[:i], param.Name[i+1:]
+
+	statType, ok := vmStatTypes[typ]
+	if !ok {
+		return vmStat{}, false
+	}
+
+	value, ok := typedParamFloat(param)
+	if !ok {
+		return vmStat{}, false
+	}
+
+	metricName := invalidMetricNameChars.ReplaceAllString(name, "_")
+	if base, ok := strings.CutSuffix(metricName, "_ns"); ok {
+		metricName = base + "_seconds"
+		value *= nanoseconds
+	}
+
+	return vmStat{
+		name:      prefix + metricName + statType.suffix,
+		help:      "hypervisor statistic " + param.Name,
+		valueType: statType.valueType,
+		value:     value,
+	}, true
+}
+
+// typedParamFloat returns the value of a numeric typed parameter.
+func typedParamFloat(param libvirt.TypedParamValue) (float64, bool) {
+	switch {
+	case param.Int != nil:
+		return float64(*param.Int), true
+	case param.UInt != nil:
+		return float64(*param.UInt), true
+	case param.Long != nil:
+		return float64(*param.Long), true
+	case param.ULong != nil:
+		return float64(*param.ULong), true
+	case param.Float != nil:
+		return *param.Float, true
+	case param.Bool != nil:
+		if *param.Bool {
+			return 1, true
+		}
+
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// collectVM exports the hypervisor statistics of the domain and of its
+// vcpus, such as the KVM exits, whichever the hypervisor reports.
+func (c *DomainStatsCollector) collectVM(dom domainLabels, stat DomainStats, ch chan<- prometheus.Metric) {
+	for _, param := range stat.VM {
+		c.sendVMStat(ch, "libvirtd_domain_vm_", param, dom)
+	}
+
+	for vcpu, vcpuStats := range stat.Vcpu {
+		for _, param := range vcpuStats.Custom {
+			c.sendVMStat(ch, "libvirtd_domain_vm_vcpu_", param, dom, strconv.Itoa(vcpu))
+		}
+	}
+}
+
+func (c *DomainStatsCollector) sendVMStat(
+	ch chan<- prometheus.Metric, prefix string, param libvirt.TypedParamValue, dom domainLabels, vcpu ...string,
+) {
+	stat, ok := newVMStat(prefix, param)
+	if !ok {
+		return
+	}
+
+	desc, ok := c.vmDescs.Load(stat.name)
+	if !ok {
+		labels := []string{}
+		if len(vcpu) > 0 {
+			labels = append(labels, "vcpu")
+		}
+
+		desc, _ = c.vmDescs.LoadOrStore(stat.name, newDomainDesc(c.NameLabel, stat.name, stat.help, labels...))
+	}
+
+	ch <- c.domainMetric(
+		desc.(*prometheus.Desc),
+		stat.valueType,
+		stat.value, dom, vcpu...,
+	)
+}
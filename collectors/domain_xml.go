@@ -0,0 +1,115 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// DomainXML holds the parts of the domain XML description used by the
+// collectors.
+type DomainXML struct {
+	Type      string           `xml:"type,attr"`
+	Name      string           `xml:"name"`
+	UUID      string           `xml:"uuid"`
+	Title     string           `xml:"title"`
+	IOThreads uint             `xml:"iothreads"`
+	OS        DomainOSXML      `xml:"os"`
+	Devices   DomainDevicesXML `xml:"devices"`
+}
+
+type DomainOSXML struct {
+	Type DomainOSTypeXML `xml:"type"`
+}
+
+type DomainOSTypeXML struct {
+	Value   string `xml:",chardata"`
+	Machine string `xml:"machine,attr"`
+}
+
+type DomainDevicesXML struct {
+	Disks      []DomainDiskXML      `xml:"disk"`
+	Interfaces []DomainInterfaceXML `xml:"interface"`
+}
+
+type DomainDiskXML struct {
+	Driver struct {
+		Type     string `xml:"type,attr"`
+		Cache    string `xml:"cache,attr"`
+		IO       string `xml:"io,attr"`
+		Discard  string `xml:"discard,attr"`
+		IOThread string `xml:"iothread,attr"`
+	} `xml:"driver"`
+	Target struct {
+		Dev string `xml:"dev,attr"`
+		Bus string `xml:"bus,attr"`
+	} `xml:"target"`
+	Serial string `xml:"serial"`
+}
+
+type DomainInterfaceXML struct {
+	MAC struct {
+		Address string `xml:"address,attr"`
+	} `xml:"mac"`
+	Source struct {
+		Bridge    string `xml:"bridge,attr"`
+		Network   string `xml:"network,attr"`
+		PortGroup string `xml:"portgroup,attr"`
+	} `xml:"source"`
+	Target struct {
+		Dev string `xml:"dev,attr"`
+	} `xml:"target"`
+	Model struct {
+		Type string `xml:"type,attr"`
+	} `xml:"model"`
+	VLAN        DomainInterfaceVLANXML `xml:"vlan"`
+	VirtualPort struct {
+		Parameters struct {
+			InterfaceID string `xml:"interfaceid,attr"`
+		} `xml:"parameters"`
+	} `xml:"virtualport"`
+}
+
+type DomainInterfaceVLANXML struct {
+	Tags []struct {
+		ID string `xml:"id,attr"`
+	} `xml:"tag"`
+}
+
+// String returns the VLAN tags of the interface separated by commas.
+func (v DomainInterfaceVLANXML) String() string {
+	ids := make([]string, 0, len(v.Tags))
+	for _, tag := range v.Tags {
+		ids = append(ids, tag.ID)
+	}
+
+	return strings.Join(ids, ",")
+}
+
+func getDomainXML(domain Domain) (*DomainXML, error) {
+	data, err := domain.GetXMLDesc(0)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DomainXML{}
+	err = xml.Unmarshal([]byte(data), d)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
@@ -0,0 +1,195 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/promslog"
+	"libvirt.org/go/libvirt"
+)
+
+// fakeConnect is an in-memory libvirt connection.
+type fakeConnect struct {
+	hypervisorType    string
+	hypervisorVersion uint32
+	libVersion        uint32
+
+	domains  []Domain
+	stats    []DomainStats
+	statsErr error
+
+	// listFlags records the flags of the last call to ListAllDomains.
+	listFlags libvirt.ConnectListAllDomainsFlags
+
+	// requested, statsTypes and statsFlags record the domains, stats and
+	// flags requested by the last call to GetAllDomainStats.
+	requested  []Domain
+	statsTypes libvirt.DomainStatsTypes
+	statsFlags libvirt.ConnectGetAllDomainStatsFlags
+
+	// unsupportedFlags are rejected by GetAllDomainStats, as older
+	// versions of libvirt do.
+	unsupportedFlags libvirt.ConnectGetAllDomainStatsFlags
+
+	// dead reports the connection as lost.
+	dead bool
+}
+
+func (c *fakeConnect) IsAlive() (bool, error) {
+	return !c.dead, nil
+}
+
+func (c *fakeConnect) Ref() error {
+	return nil
+}
+
+func (c *fakeConnect) Close() (int, error) {
+	return 1, nil
+}
+
+func (c *fakeConnect) GetType() (string, error) {
+	return c.hypervisorType, nil
+}
+
+func (c *fakeConnect) GetVersion() (uint32, error) {
+	return c.hypervisorVersion, nil
+}
+
+func (c *fakeConnect) GetLibVersion() (uint32, error) {
+	return c.libVersion, nil
+}
+
+func (c *fakeConnect) ListAllDomains(flags libvirt.ConnectListAllDomainsFlags) ([]Domain, error) {
+	c.listFlags = flags
+
+	return c.domains, nil
+}
+
+func (c *fakeConnect) GetAllDomainStats(
+	doms []Domain, statsTypes libvirt.DomainStatsTypes, flags libvirt.ConnectGetAllDomainStatsFlags,
+) ([]DomainStats, error) {
+	c.requested = doms
+	c.statsTypes = statsTypes
+	c.statsFlags = flags
+
+	if flags&c.unsupportedFlags != 0 {
+		return nil, libvirt.Error{Code: libvirt.ERR_INVALID_ARG}
+	}
+
+	if len(doms) == 0 {
+		return c.stats, c.statsErr
+	}
+
+	stats := []DomainStats{}
+	for _, stat := range c.stats {
+		if slices.Contains(doms, stat.Domain) {
+			stats = append(stats, stat)
+		}
+	}
+
+	return stats, c.statsErr
+}
+
+// fakeDomain is an in-memory libvirt domain.
+type fakeDomain struct {
+	uuid       string
+	name       string
+	xml        string
+	metadata   string
+	inactive   bool
+	diskErrors []libvirt.DomainDiskError
+	ioThreads  []libvirt.DomainIOThreadInfo
+
+	// diskErrorCalls and ioThreadInfoCalls count the calls to
+	// GetDiskErrors and GetIOThreadInfo.
+	diskErrorCalls    int
+	ioThreadInfoCalls int
+
+	// dirtyRateCalcs records the periods of the dirty rate calculations
+	// started on the domain, dirtyRateErr fails them.
+	dirtyRateCalcs []int
+	dirtyRateErr   error
+}
+
+func (d *fakeDomain) GetUUIDString() (string, error) {
+	return d.uuid, nil
+}
+
+func (d *fakeDomain) GetName() (string, error) {
+	return d.name, nil
+}
+
+func (d *fakeDomain) GetXMLDesc(_ libvirt.DomainXMLFlags) (string, error) {
+	return d.xml, nil
+}
+
+func (d *fakeDomain) GetMetadata(
+	_ libvirt.DomainMetadataType, _ string, flags libvirt.DomainModificationImpact,
+) (string, error) {
+	// NOTE: libvirt refuses to get the live metadata of inactive domains.
+	if d.inactive && flags&libvirt.DOMAIN_AFFECT_LIVE != 0 {
+		return "", libvirt.Error{Code: libvirt.ERR_OPERATION_INVALID}
+	}
+	if d.metadata == "" {
+		return "", libvirt.Error{Code: libvirt.ERR_NO_DOMAIN_METADATA}
+	}
+
+	return d.metadata, nil
+}
+
+func (d *fakeDomain) GetDiskErrors(_ uint32) ([]libvirt.DomainDiskError, error) {
+	d.diskErrorCalls++
+
+	return d.diskErrors, nil
+}
+
+func (d *fakeDomain) GetIOThreadInfo(_ libvirt.DomainModificationImpact) ([]libvirt.DomainIOThreadInfo, error) {
+	d.ioThreadInfoCalls++
+
+	return d.ioThreads, nil
+}
+
+func (d *fakeDomain) StartDirtyRateCalc(secs int, _ libvirt.DomainDirtyRateCalcFlags) error {
+	d.dirtyRateCalcs = append(d.dirtyRateCalcs, secs)
+
+	return d.dirtyRateErr
+}
+
+func (d *fakeDomain) Free() error {
+	return nil
+}
+
+// newFakeConnection returns a connection manager handing out conn.
+func newFakeConnection(conn Connect) *Connection {
+	c := NewConnection(promslog.NewNopLogger(), "test:///default", ConnectionOptions{})
+	c.dial = func() (Connect, error) {
+		return conn, nil
+	}
+
+	return c
+}
+
+// collectorFunc turns a function sending metrics into a collector.
+type collectorFunc func(ch chan<- prometheus.Metric)
+
+func (f collectorFunc) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(f, ch)
+}
+
+func (f collectorFunc) Collect(ch chan<- prometheus.Metric) {
+	f(ch)
+}
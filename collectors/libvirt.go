@@ -0,0 +1,103 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"libvirt.org/go/libvirt"
+)
+
+// Connect is the subset of *libvirt.Connect used by the collectors, so
+// that they can be exercised against a fake in tests.
+type Connect interface {
+	IsAlive() (bool, error)
+	Ref() error
+	Close() (int, error)
+
+	GetType() (string, error)
+	GetVersion() (uint32, error)
+	GetLibVersion() (uint32, error)
+
+	ListAllDomains(flags libvirt.ConnectListAllDomainsFlags) ([]Domain, error)
+	GetAllDomainStats(
+		doms []Domain, statsTypes libvirt.DomainStatsTypes, flags libvirt.ConnectGetAllDomainStatsFlags,
+	) ([]DomainStats, error)
+}
+
+// Domain is the subset of *libvirt.Domain used by the collectors.
+type Domain interface {
+	GetUUIDString() (string, error)
+	GetName() (string, error)
+	GetXMLDesc(flags libvirt.DomainXMLFlags) (string, error)
+	GetMetadata(
+		tipe libvirt.DomainMetadataType, uri string, flags libvirt.DomainModificationImpact,
+	) (string, error)
+	GetDiskErrors(flags uint32) ([]libvirt.DomainDiskError, error)
+	GetIOThreadInfo(flags libvirt.DomainModificationImpact) ([]libvirt.DomainIOThreadInfo, error)
+	StartDirtyRateCalc(secs int, flags libvirt.DomainDirtyRateCalcFlags) error
+	Free() error
+}
+
+// DomainStats are the stats of a single domain, with the domain itself
+// behind the Domain interface.
+type DomainStats struct {
+	libvirt.DomainStats
+
+	Domain Domain
+}
+
+// libvirtConnect adapts *libvirt.Connect to the Connect interface.
+type libvirtConnect struct {
+	*libvirt.Connect
+}
+
+func (c libvirtConnect) ListAllDomains(flags libvirt.ConnectListAllDomainsFlags) ([]Domain, error) {
+	doms, err := c.Connect.ListAllDomains(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Domain, 0, len(doms))
+	for i := range doms {
+		result = append(result, libvirtDomain{&doms[i]})
+	}
+
+	return result, nil
+}
+
+func (c libvirtConnect) GetAllDomainStats(
+	doms []Domain, statsTypes libvirt.DomainStatsTypes, flags libvirt.ConnectGetAllDomainStatsFlags,
+) ([]DomainStats, error) {
+	domains := make([]*libvirt.Domain, 0, len(doms))
+	for _, dom := range doms {
+		domains = append(domains, dom.(libvirtDomain).Domain)
+	}
+
+	stats, err := c.Connect.GetAllDomainStats(domains, statsTypes, flags)
+
+	result := make([]DomainStats, 0, len(stats))
+	for _, stat := range stats {
+		result = append(result, DomainStats{
+			DomainStats: stat,
+			Domain:      libvirtDomain{stat.Domain},
+		})
+	}
+
+	return result, err
+}
+
+// libvirtDomain adapts *libvirt.Domain to the Domain interface.
+type libvirtDomain struct {
+	*libvirt.Domain
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NamingScheme selects the names metrics are exported under.
+type NamingScheme string
+
+const (
+	// NamingLegacy exports metrics under their original names, in the
+	// units returned by libvirt.
+	NamingLegacy NamingScheme = "legacy"
+
+	// NamingConventional exports metrics following the Prometheus naming
+	// conventions, in base units.
+	NamingConventional NamingScheme = "conventional"
+
+	// NamingBoth exports both sets, to migrate dashboards and alerts.
+	NamingBoth NamingScheme = "both"
+)
+
+// NamingSchemes lists the valid naming schemes.
+var NamingSchemes = []NamingScheme{NamingLegacy, NamingConventional, NamingBoth}
+
+// ParseNamingScheme validates the name of a naming scheme.
+func ParseNamingScheme(s string) (NamingScheme, error) {
+	for _, scheme := range NamingSchemes {
+		if NamingScheme(s) == scheme {
+			return scheme, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown naming scheme %q", s)
+}
+
+// Scales converting the units returned by libvirt to base units.
+const (
+	nanoseconds = 1e-9
+	kibibytes   = 1024
+	mebibytes   = 1024 * 1024
+)
+
+// namedDesc describes a metric both under its legacy name and under its
+// conventional name, the value of the latter being scaled to base units.
+type namedDesc struct {
+	scheme NamingScheme
+
+	legacy     *prometheus.Desc
+	legacyType prometheus.ValueType
+
+	conventional     *prometheus.Desc
+	conventionalType prometheus.ValueType
+	scale            float64
+}
+
+func (d *namedDesc) Describe(ch chan<- *prometheus.Desc) {
+	if d.scheme != NamingConventional {
+		ch <- d.legacy
+	}
+	if d.scheme != NamingLegacy {
+		ch <- d.conventional
+	}
+}
+
+// send sends the metrics of the naming scheme for the value in the legacy
+// unit.
+func (d *namedDesc) send(ch chan<- prometheus.Metric, value float64, labels ...string) {
+	if d.scheme != NamingConventional {
+		ch <- prometheus.MustNewConstMetric(d.legacy, d.legacyType, value, labels...)
+	}
+	if d.scheme != NamingLegacy {
+		ch <- prometheus.MustNewConstMetric(d.conventional, d.conventionalType, value*d.scale, labels...)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Poller scrapes collectors on a fixed interval in the background and
+// serves the last snapshot, so that scrapes never wait on libvirt and
+// several Prometheus servers scraping the exporter cost a single poll.
+type Poller struct {
+	logger     *slog.Logger
+	collectors []prometheus.Collector
+	interval   time.Duration
+	timeout    time.Duration
+	timestamps bool
+
+	mu       sync.RWMutex
+	families []*dto.MetricFamily
+	polledAt time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	registry *prometheus.Registry
+}
+
+// NewPoller creates a poller scraping the collectors every interval, each
+// poll being bounded by timeout.  If timestamps is set, every sample is
+// stamped with the time of the poll it comes from.
+func NewPoller(
+	logger *slog.Logger, collectors []prometheus.Collector, interval time.Duration, timeout time.Duration,
+	timestamps bool,
+) *Poller {
+	p := &Poller{
+		logger:     logger,
+		collectors: collectors,
+		interval:   interval,
+		timeout:    timeout,
+		timestamps: timestamps,
+		registry:   prometheus.NewRegistry(),
+	}
+
+	p.registry.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "libvirtd_scrape_snapshot_age_seconds",
+			Help: "age of the polled snapshot being served in seconds",
+		},
+		func() float64 {
+			p.mu.RLock()
+			defer p.mu.RUnlock()
+
+			return time.Since(p.polledAt).Seconds()
+		},
+	))
+
+	return p
+}
+
+// Start takes a first snapshot and keeps polling in the background until
+// Stop is called.
+func (p *Poller) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+
+	p.poll(ctx)
+
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.poll(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops polling, the last snapshot keeps being served.
+func (p *Poller) Stop() {
+	p.cancel()
+	<-p.done
+}
+
+// Gather returns the last snapshot.
+func (p *Poller) Gather() ([]*dto.MetricFamily, error) {
+	p.mu.RLock()
+	families := p.families
+	p.mu.RUnlock()
+
+	return prometheus.Gatherers{
+		prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return families, nil
+		}),
+		p.registry,
+	}.Gather()
+}
+
+func (p *Poller) poll(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	polledAt := time.Now()
+
+	reg := prometheus.NewRegistry()
+	for _, c := range p.collectors {
+		err := reg.Register(WithContext(ctx, c))
+		if err != nil {
+			p.logger.Error("Failed to register collector for polling", "err", err)
+			return
+		}
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		p.logger.Error("Failed to poll collectors", "err", err)
+	}
+
+	if p.timestamps {
+		timestamp := polledAt.UnixMilli()
+		for _, family := range families {
+			for _, metric := range family.Metric {
+				metric.TimestampMs = &timestamp
+			}
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.families = families
+	p.polledAt = polledAt
+}
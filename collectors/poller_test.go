@@ -0,0 +1,151 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/promslog"
+)
+
+// newTestPoller returns a poller of a collector reporting value, which only
+// polls when started.
+func newTestPoller(value *atomic.Int64, timestamps bool) *Poller {
+	c := collectorFunc(func(ch chan<- prometheus.Metric) {
+		ch <- prometheus.MustNewConstMetric(testScrapeDesc, prometheus.GaugeValue, float64(value.Load()))
+	})
+
+	return NewPoller(promslog.NewNopLogger(), []prometheus.Collector{c}, time.Hour, time.Second, timestamps)
+}
+
+// gatherPoller returns the families served by the poller by name.
+func gatherPoller(t *testing.T, p *Poller) map[string]*dto.MetricFamily {
+	t.Helper()
+
+	families, err := p.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := map[string]*dto.MetricFamily{}
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+
+	return byName
+}
+
+// polledValue returns the value of the test metric served by the poller.
+func polledValue(t *testing.T, p *Poller) float64 {
+	t.Helper()
+
+	family, ok := gatherPoller(t, p)["libvirtd_test"]
+	if !ok {
+		t.Fatal("missing metric family libvirtd_test")
+	}
+
+	return family.Metric[0].GetGauge().GetValue()
+}
+
+func TestPollerSnapshot(t *testing.T) {
+	var value atomic.Int64
+	value.Store(1)
+
+	p := newTestPoller(&value, false)
+	p.Start()
+
+	value.Store(2)
+	if got := polledValue(t, p); got != 1 {
+		t.Errorf("served %v, want the snapshot taken on start", got)
+	}
+
+	families := gatherPoller(t, p)
+	if families["libvirtd_test"].Metric[0].TimestampMs != nil {
+		t.Error("stamped the samples with the time of the poll, want no timestamp")
+	}
+
+	age, ok := families["libvirtd_scrape_snapshot_age_seconds"]
+	if !ok {
+		t.Fatal("missing metric family libvirtd_scrape_snapshot_age_seconds")
+	}
+	if seconds := age.Metric[0].GetGauge().GetValue(); seconds < 0 || seconds > 10 {
+		t.Errorf("snapshot is %v seconds old, want it just taken", seconds)
+	}
+
+	p.Stop()
+	if got := polledValue(t, p); got != 1 {
+		t.Errorf("served %v once stopped, want the last snapshot", got)
+	}
+
+	p.Start()
+	defer p.Stop()
+
+	if got := polledValue(t, p); got != 2 {
+		t.Errorf("served %v once restarted, want a fresh snapshot", got)
+	}
+}
+
+func TestPollerReload(t *testing.T) {
+	var value atomic.Int64
+	value.Store(1)
+
+	old := newTestPoller(&value, false)
+	old.Start()
+
+	value.Store(2)
+	p := newTestPoller(&value, false)
+
+	// NOTE: As done on reload, the old poller stops before the new one
+	//       starts.
+	old.Stop()
+	p.Start()
+	defer p.Stop()
+
+	if got := polledValue(t, old); got != 1 {
+		t.Errorf("old poller served %v, want its last snapshot", got)
+	}
+	if got := polledValue(t, p); got != 2 {
+		t.Errorf("new poller served %v, want its own snapshot", got)
+	}
+}
+
+func TestPollerTimestamps(t *testing.T) {
+	var value atomic.Int64
+
+	p := newTestPoller(&value, true)
+
+	before := time.Now().UnixMilli()
+	p.Start()
+	defer p.Stop()
+	after := time.Now().UnixMilli()
+
+	for name, family := range gatherPoller(t, p) {
+		if name == "libvirtd_scrape_snapshot_age_seconds" {
+			if family.Metric[0].TimestampMs != nil {
+				t.Error("stamped the snapshot age with the time of the poll, want no timestamp")
+			}
+			continue
+		}
+
+		timestamp := family.Metric[0].GetTimestampMs()
+		if timestamp < before || timestamp > after {
+			t.Errorf("stamped %s with %d, want the time of the poll between %d and %d", name, timestamp, before, after)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// defaultIdleTimeout is how long a connection of the pool may go unused
+// before it is closed.
+const defaultIdleTimeout = 15 * time.Minute
+
+// ConnectionPool hands out a single shared Connection per libvirt URI so
+// that repeated probes of the same target reuse the same connection.
+// Connections left unused for longer than the idle timeout are closed and
+// dropped, except the kept one, so that probing many targets does not hold
+// on to their connections forever.
+type ConnectionPool struct {
+	logger      *slog.Logger
+	options     ConnectionOptions
+	idleTimeout time.Duration
+
+	mu          sync.Mutex
+	connections map[string]*Connection
+	kept        string
+}
+
+func NewConnectionPool(logger *slog.Logger, options ConnectionOptions) *ConnectionPool {
+	return &ConnectionPool{
+		logger:      logger,
+		options:     options,
+		idleTimeout: defaultIdleTimeout,
+		connections: map[string]*Connection{},
+	}
+}
+
+// Get returns the connection for the given URI, creating it on first use.
+// The connection itself is only opened once a collector needs it.
+func (p *ConnectionPool) Get(uri string) *Connection {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.evictIdle()
+
+	if conn, ok := p.connections[uri]; ok {
+		conn.touch()
+		return conn
+	}
+
+	conn := NewConnection(p.logger.With("uri", uri), uri, p.options)
+	p.connections[uri] = conn
+
+	return conn
+}
+
+// Keep exempts the connection to uri from eviction, in place of the one
+// kept before.  It is meant for the URI the collectors of /metrics are
+// bound to, which hold on to their connection.
+func (p *ConnectionPool) Keep(uri string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.kept = uri
+}
+
+// evictIdle drops the connections that have been idle for too long, it
+// must be called with the lock held.
+func (p *ConnectionPool) evictIdle() {
+	for uri, conn := range p.connections {
+		if uri == p.kept || conn.idleFor() < p.idleTimeout {
+			continue
+		}
+
+		delete(p.connections, uri)
+
+		// NOTE: Closing waits on any reconnect in progress, which can
+		//       hang on an unreachable target.
+		go func() {
+			err := conn.Close()
+			if err != nil {
+				p.logger.Error("Failed to close idle connection", "uri", uri, "err", err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/promslog"
+)
+
+func TestConnectionPoolEvictsIdle(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time {
+		return start
+	}
+	t.Cleanup(func() {
+		now = time.Now
+	})
+
+	p := NewConnectionPool(promslog.NewNopLogger(), ConnectionOptions{})
+	p.Keep("qemu:///system")
+
+	local := p.Get("qemu:///system")
+	idle := p.Get("qemu+ssh://compute-1/system")
+	used := p.Get("qemu+ssh://compute-2/system")
+
+	now = func() time.Time {
+		return start.Add(10 * time.Minute)
+	}
+	if p.Get("qemu+ssh://compute-2/system") != used {
+		t.Fatal("got a new connection for a target in use")
+	}
+
+	now = func() time.Time {
+		return start.Add(20 * time.Minute)
+	}
+	if p.Get("qemu+ssh://compute-2/system") != used {
+		t.Error("evicted a connection used within the idle timeout")
+	}
+	if p.Get("qemu:///system") != local {
+		t.Error("evicted the kept connection")
+	}
+	if p.Get("qemu+ssh://compute-1/system") == idle {
+		t.Error("kept a connection idle for longer than the idle timeout")
+	}
+
+	if len(p.connections) != 3 {
+		t.Errorf("pool holds %d connections, want 3", len(p.connections))
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultEnabled  = true
+	defaultDisabled = false
+)
+
+// Factory creates a collector bound to the given connection.
+type Factory func(logger *slog.Logger, connection *Connection, opts *Options) prometheus.Collector
+
+type registration struct {
+	name             string
+	help             string
+	isDefaultEnabled bool
+	factory          Factory
+}
+
+var registrations = map[string]*registration{}
+
+// registerCollector makes a collector available under the given name, it
+// can then be toggled with --collector.<name> and --no-collector.<name>.
+func registerCollector(name string, isDefaultEnabled bool, factory Factory) {
+	register(&registration{
+		name:             name,
+		help:             fmt.Sprintf("Enable the %s collector", name),
+		isDefaultEnabled: isDefaultEnabled,
+		factory:          factory,
+	})
+}
+
+// registerCollectorGroup makes a group of metrics within a collector
+// toggleable under the name <collector>.<group>.
+func registerCollectorGroup(collector string, group string, isDefaultEnabled bool) {
+	register(&registration{
+		name:             collector + "." + group,
+		help:             fmt.Sprintf("Enable the %s metrics of the %s collector", group, collector),
+		isDefaultEnabled: isDefaultEnabled,
+	})
+}
+
+func register(r *registration) {
+	if _, ok := registrations[r.name]; ok {
+		panic(fmt.Sprintf("collector %q registered twice", r.name))
+	}
+
+	registrations[r.name] = r
+}
+
+// Options holds the settings shared by every collector.
+type Options struct {
+	// Collectors holds whether each collector, and each collector group,
+	// is enabled keyed by its name.
+	Collectors map[string]bool
+
+	Nova bool
+
+	// DomainNameLabel adds the domain name as a label to every metric of
+	// a domain.
+	DomainNameLabel bool
+
+	// NamingScheme selects the names metrics are exported under.
+	NamingScheme NamingScheme
+
+	// DomainFilter selects the domains scraped by the domain stats
+	// collector.
+	DomainFilter DomainFilter
+
+	// DomainStatsNoWait gets the stats of domains without waiting on those
+	// busy with a job, whose stats are then incomplete.
+	DomainStatsNoWait bool
+
+	// DomainBlockBacking reports the block stats of every layer of the
+	// backing chain of disks instead of only the top one.
+	DomainBlockBacking bool
+
+	// DirtyRateCalcInterval, if set, starts a dirty rate calculation of
+	// DirtyRateCalcPeriod on running domains on this interval.
+	DirtyRateCalcInterval time.Duration
+	DirtyRateCalcPeriod   time.Duration
+}
+
+// DefaultOptions returns options with every collector set to its default.
+func DefaultOptions() *Options {
+	opts := &Options{
+		Collectors:        make(map[string]bool, len(registrations)),
+		NamingScheme:      NamingLegacy,
+		DomainStatsNoWait: true,
+	}
+
+	for name, r := range registrations {
+		opts.Collectors[name] = r.isDefaultEnabled
+	}
+
+	return opts
+}
+
+// IsEnabled returns whether the named collector or collector group is
+// enabled, falling back to its default if it was not set.
+func (o *Options) IsEnabled(name string) bool {
+	if enabled, ok := o.Collectors[name]; ok {
+		return enabled
+	}
+
+	r, ok := registrations[name]
+
+	return ok && r.isDefaultEnabled
+}
+
+// AddFlags adds a --collector.<name> flag, which can be negated with
+// --no-collector.<name>, for every registered collector and collector group.
+func AddFlags(app *kingpin.Application, opts *Options) {
+	for _, name := range Names() {
+		r := registrations[name]
+
+		help := fmt.Sprintf("%s (default: %s).", r.help, enabledString(r.isDefaultEnabled))
+		app.Flag("collector."+name, help).
+			Default(strconv.FormatBool(r.isDefaultEnabled)).
+			SetValue(&collectorFlag{name: name, opts: opts})
+	}
+}
+
+// Names returns the names of every registered collector and collector
+// group in alphabetical order.
+func Names() []string {
+	names := make([]string, 0, len(registrations))
+	for name := range registrations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// NewCollectors creates every enabled collector against the connection.
+func NewCollectors(logger *slog.Logger, connection *Connection, opts *Options) []prometheus.Collector {
+	collectors := []prometheus.Collector{}
+
+	for _, name := range Names() {
+		r := registrations[name]
+		if r.factory == nil || !opts.IsEnabled(name) {
+			continue
+		}
+
+		collectors = append(collectors, r.factory(logger, connection, opts))
+	}
+
+	return collectors
+}
+
+func enabledString(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+
+	return "disabled"
+}
+
+// collectorFlag is a boolean kingpin value writing into Options.Collectors.
+type collectorFlag struct {
+	name string
+	opts *Options
+}
+
+func (f *collectorFlag) Set(value string) error {
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+
+	f.opts.Collectors[f.name] = enabled
+
+	return nil
+}
+
+func (f *collectorFlag) String() string {
+	return strconv.FormatBool(f.opts.IsEnabled(f.name))
+}
+
+func (f *collectorFlag) IsBoolFlag() bool {
+	return true
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"testing"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+func TestAddFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected map[string]bool
+	}{
+		{
+			name: "defaults",
+			expected: map[string]bool{
+				"domain_stats":               true,
+				"domain_stats.block":         true,
+				"domain_stats.info":          false,
+				"domain_stats.block_errors":  false,
+				"domain_stats.iothread_info": false,
+				"domain_stats.dirtyrate":     false,
+				"domain_stats.vm":            false,
+				"version":                    true,
+			},
+		},
+		{
+			name: "enabled",
+			args: []string{"--collector.domain_stats.dirtyrate", "--collector.domain_stats.vm"},
+			expected: map[string]bool{
+				"domain_stats.dirtyrate": true,
+				"domain_stats.vm":        true,
+			},
+		},
+		{
+			name: "negated",
+			args: []string{"--no-collector.version", "--no-collector.domain_stats.block", "--no-collector.domain_stats.net"},
+			expected: map[string]bool{
+				"version":            false,
+				"domain_stats.block": false,
+				"domain_stats.net":   false,
+				"domain_stats":       true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+
+			app := kingpin.New("test", "")
+			AddFlags(app, opts)
+
+			for _, name := range Names() {
+				if app.GetFlag("collector."+name) == nil {
+					t.Errorf("missing flag --collector.%s", name)
+				}
+			}
+
+			_, err := app.Parse(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, enabled := range tt.expected {
+				if opts.IsEnabled(name) != enabled {
+					t.Errorf("%s is enabled: %t, want %t", name, opts.IsEnabled(name), enabled)
+				}
+			}
+		})
+	}
+}
+
+func TestOptionsIsEnabled(t *testing.T) {
+	opts := &Options{Collectors: map[string]bool{"version": false}}
+
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{"version", false},
+		{"domain_stats", true},
+		{"domain_stats.vm", false},
+		{"hypervisor", false},
+		{"domain_stats.hypervisor", false},
+	}
+
+	for _, tt := range tests {
+		if opts.IsEnabled(tt.name) != tt.enabled {
+			t.Errorf("%s is enabled: %t, want %t", tt.name, opts.IsEnabled(tt.name), tt.enabled)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"libvirt.org/go/libvirt"
+)
+
+// errScrapeInProgress is returned when a collector is scraped while a
+// previous scrape of it is still waiting on libvirt.
+var errScrapeInProgress = errors.New("previous scrape is still waiting on libvirt")
+
+// ContextCollector is a collector whose calls to libvirt can be bounded by
+// a context.
+type ContextCollector interface {
+	prometheus.Collector
+
+	CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric)
+}
+
+// WithContext binds a collector to ctx for the duration of a single
+// scrape, collectors that do not support a context are returned as is.
+func WithContext(ctx context.Context, c prometheus.Collector) prometheus.Collector {
+	if cc, ok := c.(ContextCollector); ok {
+		return &boundCollector{ContextCollector: cc, ctx: ctx}
+	}
+
+	return c
+}
+
+type boundCollector struct {
+	ContextCollector
+
+	ctx context.Context
+}
+
+func (c *boundCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectWithContext(c.ctx, ch)
+}
+
+// collectFunc collects metrics from a live connection.
+type collectFunc func(ctx context.Context, conn Connect, ch chan<- prometheus.Metric) error
+
+// scrapeMetrics reports how long a collector took to scrape libvirt and
+// whether it succeeded or timed out.
+type scrapeMetrics struct {
+	name string
+
+	Duration *prometheus.Desc
+	Success  *prometheus.Desc
+	Timeout  *prometheus.Desc
+}
+
+func newScrapeMetrics(name string) *scrapeMetrics {
+	labels := prometheus.Labels{"collector": name}
+
+	return &scrapeMetrics{
+		name: name,
+
+		Duration: prometheus.NewDesc(
+			"libvirtd_scrape_duration_seconds",
+			"duration of the collector scrape in seconds",
+			nil, labels,
+		),
+		Success: prometheus.NewDesc(
+			"libvirtd_scrape_success",
+			"whether the collector scrape succeeded",
+			nil, labels,
+		),
+		Timeout: prometheus.NewDesc(
+			"libvirtd_scrape_timeout",
+			"whether the collector scrape timed out waiting on libvirt, its metrics are then partial",
+			nil, labels,
+		),
+	}
+}
+
+func (s *scrapeMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.Duration
+	ch <- s.Success
+	ch <- s.Timeout
+}
+
+// collect hands a live connection to fn and reports the outcome, logging
+// and counting any error against the connection.  If ctx is done before fn
+// returns, the metrics sent so far are kept and fn is left to finish in the
+// background, while further scrapes of the collector are refused until it
+// does so that hung libvirt calls do not pile up.  Overlapping scrapes that
+// are still within their deadline wait for each other instead.
+func (s *scrapeMetrics) collect(
+	ctx context.Context, logger *slog.Logger, connection *Connection, ch chan<- prometheus.Metric, fn collectFunc,
+) {
+	start := time.Now()
+	err := runScrape(ctx, connection, s.name, ch, fn)
+	duration := time.Since(start)
+
+	success := float64(1)
+	timeout := float64(0)
+	if err != nil {
+		logger.Error("Collector failed", "collector", s.name, "err", err)
+		connection.CountError(s.name, err)
+		success = 0
+
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errScrapeInProgress) {
+			timeout = 1
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		s.Duration,
+		prometheus.GaugeValue,
+		duration.Seconds(),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		s.Success,
+		prometheus.GaugeValue,
+		success,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		s.Timeout,
+		prometheus.GaugeValue,
+		timeout,
+	)
+}
+
+// runScrape hands a live connection to fn on behalf of the named collector,
+// returning early once ctx is done, see scrapeMetrics.collect.
+func runScrape(
+	ctx context.Context, connection *Connection, collector string, ch chan<- prometheus.Metric, fn collectFunc,
+) error {
+	scrape, err := connection.acquire(ctx, collector)
+	if err != nil {
+		return err
+	}
+
+	results := make(chan prometheus.Metric)
+	done := make(chan error, 1)
+
+	go func() {
+		defer connection.release(collector, scrape)
+		defer close(results)
+
+		conn, err := connection.Connect()
+		if err != nil {
+			done <- err
+			return
+		}
+
+		err = fn(ctx, conn, results)
+
+		_, closeErr := conn.Close()
+		done <- errors.Join(err, closeErr)
+	}()
+
+	for {
+		select {
+		case m, ok := <-results:
+			if !ok {
+				return <-done
+			}
+			ch <- m
+		case <-ctx.Done():
+			scrape.expired.Store(true)
+
+			// NOTE: Keep draining so that the hung call can finish and
+			//       release the collector once libvirt answers.
+			go func() {
+				for range results {
+				}
+			}()
+
+			return ctx.Err()
+		}
+	}
+}
+
+// errorCode returns the libvirt error code of err, "timeout" if libvirt
+// did not answer in time or "unknown" if err did not originate from libvirt.
+func errorCode(err error) string {
+	var virErr libvirt.Error
+	if errors.As(err, &virErr) {
+		return strconv.Itoa(int(virErr.Code))
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errScrapeInProgress) {
+		return "timeout"
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,258 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+	"libvirt.org/go/libvirt"
+)
+
+var testScrapeDesc = prometheus.NewDesc("libvirtd_test", "test metric", nil, nil)
+
+// testScrapeCollector scrapes fn as the "test" collector.
+type testScrapeCollector struct {
+	scrape     *scrapeMetrics
+	connection *Connection
+	fn         collectFunc
+}
+
+func newTestScrapeCollector(connection *Connection, fn collectFunc) *testScrapeCollector {
+	return &testScrapeCollector{
+		scrape:     newScrapeMetrics("test"),
+		connection: connection,
+		fn:         fn,
+	}
+}
+
+func (c *testScrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.scrape.Describe(ch)
+	ch <- testScrapeDesc
+}
+
+func (c *testScrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectWithContext(context.Background(), ch)
+}
+
+func (c *testScrapeCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	c.scrape.collect(ctx, promslog.NewNopLogger(), c.connection, ch, c.fn)
+}
+
+// sendTestMetric sends the test metric with the given value.
+func sendTestMetric(ch chan<- prometheus.Metric, value float64) {
+	ch <- prometheus.MustNewConstMetric(testScrapeDesc, prometheus.GaugeValue, value)
+}
+
+// expectedScrape returns the scrape metrics of the test collector, along
+// with the test metric if it was sent.
+func expectedScrape(success bool, timeout bool, metrics string) string {
+	value := func(b bool) string {
+		if b {
+			return "1"
+		}
+
+		return "0"
+	}
+
+	return metrics + `
+# HELP libvirtd_scrape_success whether the collector scrape succeeded
+# TYPE libvirtd_scrape_success gauge
+libvirtd_scrape_success{collector="test"} ` + value(success) + `
+# HELP libvirtd_scrape_timeout whether the collector scrape timed out waiting on libvirt, its metrics are then partial
+# TYPE libvirtd_scrape_timeout gauge
+libvirtd_scrape_timeout{collector="test"} ` + value(timeout) + `
+`
+}
+
+const testMetric = `
+# HELP libvirtd_test test metric
+# TYPE libvirtd_test gauge
+libvirtd_test 1
+`
+
+// compareScrape scrapes c bound to ctx and compares the outcome.
+func compareScrape(ctx context.Context, c *testScrapeCollector, expected string) error {
+	return testutil.CollectAndCompare(
+		WithContext(ctx, c), strings.NewReader(expected),
+		"libvirtd_test", "libvirtd_scrape_success", "libvirtd_scrape_timeout",
+	)
+}
+
+func TestScrapeOverlapping(t *testing.T) {
+	connection := newFakeConnection(&fakeConnect{})
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	calls := 0
+
+	c := newTestScrapeCollector(connection, func(_ context.Context, _ Connect, ch chan<- prometheus.Metric) error {
+		calls++
+		if calls == 1 {
+			close(started)
+			<-unblock
+		}
+
+		sendTestMetric(ch, 1)
+
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := compareScrape(context.Background(), c, expectedScrape(true, false, testMetric))
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+
+		// NOTE: Make sure the second scrape overlaps the first one.
+		<-started
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(unblock)
+	wg.Wait()
+
+	if calls != 2 {
+		t.Errorf("collected %d times, want 2", calls)
+	}
+}
+
+func TestScrapeHung(t *testing.T) {
+	connection := newFakeConnection(&fakeConnect{})
+
+	unblock := make(chan struct{})
+	finished := make(chan struct{})
+	var hung atomic.Bool
+	hung.Store(true)
+
+	c := newTestScrapeCollector(connection, func(_ context.Context, _ Connect, ch chan<- prometheus.Metric) error {
+		if hung.Load() {
+			defer close(finished)
+			<-unblock
+		}
+
+		sendTestMetric(ch, 1)
+
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := compareScrape(ctx, c, expectedScrape(false, true, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NOTE: The hung call outlived its deadline, the next scrape is refused
+	//       right away instead of waiting on it.
+	err = compareScrape(context.Background(), c, expectedScrape(false, true, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := testutil.ToFloat64(connection.Errors.WithLabelValues("test", "timeout"))
+	if count != 2 {
+		t.Errorf("counted %v timeouts, want 2", count)
+	}
+
+	hung.Store(false)
+	close(unblock)
+	<-finished
+
+	// NOTE: The collector is released once the hung call returned and its
+	//       connection was closed.
+	waitReleased(t, connection, "test")
+
+	err = compareScrape(context.Background(), c, expectedScrape(true, false, testMetric))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScrapePartial(t *testing.T) {
+	connection := newFakeConnection(&fakeConnect{})
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	c := newTestScrapeCollector(connection, func(ctx context.Context, _ Connect, ch chan<- prometheus.Metric) error {
+		sendTestMetric(ch, 1)
+		<-unblock
+
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := compareScrape(ctx, c, expectedScrape(false, true, testMetric))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{libvirt.Error{Code: libvirt.ERR_OPERATION_TIMEOUT}, "68"},
+		{fmt.Errorf("failed to get domain stats: %w", libvirt.Error{Code: libvirt.ERR_NO_DOMAIN}), "42"},
+		{context.DeadlineExceeded, "timeout"},
+		{errScrapeInProgress, "timeout"},
+		{errors.New("connection refused"), "unknown"},
+	}
+
+	for _, tt := range tests {
+		if code := errorCode(tt.err); code != tt.code {
+			t.Errorf("errorCode(%v) = %q, want %q", tt.err, code, tt.code)
+		}
+	}
+}
+
+// waitReleased waits for the scrape of the named collector in flight to end.
+func waitReleased(t *testing.T, connection *Connection, collector string) {
+	t.Helper()
+
+	connection.inflightMu.Lock()
+	scrape, ok := connection.inflight[collector]
+	connection.inflightMu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	select {
+	case <-scrape.done:
+	case <-time.After(time.Second):
+		t.Fatalf("scrape of the %s collector is still in flight", collector)
+	}
+}
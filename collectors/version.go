@@ -15,26 +15,34 @@
 package collectors
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"libvirt.org/go/libvirt"
 )
 
+func init() {
+	registerCollector("version", defaultEnabled, func(logger *slog.Logger, connection *Connection, _ *Options) prometheus.Collector {
+		return NewVersionCollector(logger, connection)
+	})
+}
+
 type VersionCollector struct {
 	prometheus.Collector
 
 	logger     *slog.Logger
-	connection *libvirt.Connect
+	connection *Connection
+	scrape     *scrapeMetrics
 
 	Version *prometheus.Desc
 }
 
-func NewVersionCollector(logger *slog.Logger, connection *libvirt.Connect) *VersionCollector {
+func NewVersionCollector(logger *slog.Logger, connection *Connection) *VersionCollector {
 	return &VersionCollector{
 		logger:     logger,
 		connection: connection,
+		scrape:     newScrapeMetrics("version"),
 
 		Version: prometheus.NewDesc(
 			"libvirtd_info",
@@ -45,54 +53,32 @@ func NewVersionCollector(logger *slog.Logger, connection *libvirt.Connect) *Vers
 }
 
 func (c *VersionCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.scrape.Describe(ch)
 	ch <- c.Version
 }
 
 func (c *VersionCollector) Collect(ch chan<- prometheus.Metric) {
-	alive, err := c.connection.IsAlive()
-	if err != nil {
-		c.logger.Error("Failed to check if connection is alive", "err", err)
-		return
-	}
+	c.CollectWithContext(context.Background(), ch)
+}
 
-	if !alive {
-		uri, err := c.connection.GetURI()
-		if err != nil {
-			// NOTE(mnaser): If we get to this point, we don't have
-			//               a URI and we can't reconnect, die
-			c.logger.Error("Failed to get URI", "err", err)
-			return
-		}
-
-		_, err = c.connection.Close()
-		if err != nil {
-			c.logger.Error("Failed to close connection", "err", err)
-		}
-
-		conn, err := libvirt.NewConnect(uri)
-		if err != nil {
-			c.logger.Error("Failed to reconnect", "err", err)
-			return
-		}
-		c.connection = conn
-	}
+func (c *VersionCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	c.scrape.collect(ctx, c.logger, c.connection, ch, c.collect)
+}
 
-	hypervisorType, err := c.connection.GetType()
+func (c *VersionCollector) collect(_ context.Context, conn Connect, ch chan<- prometheus.Metric) error {
+	hypervisorType, err := conn.GetType()
 	if err != nil {
-		c.logger.Error("Failed to get hypervisor type", "err", err)
-		return
+		return fmt.Errorf("failed to get hypervisor type: %w", err)
 	}
 
-	hypervisorVersion, err := c.connection.GetVersion()
+	hypervisorVersion, err := conn.GetVersion()
 	if err != nil {
-		c.logger.Error("Failed to get hypervisor version", "err", err)
-		return
+		return fmt.Errorf("failed to get hypervisor version: %w", err)
 	}
 
-	libvirtVersion, err := c.connection.GetLibVersion()
+	libvirtVersion, err := conn.GetLibVersion()
 	if err != nil {
-		c.logger.Error("Failed to get libvirt version", "err", err)
-		return
+		return fmt.Errorf("failed to get libvirt version: %w", err)
 	}
 
 	ch <- prometheus.MustNewConstMetric(
@@ -103,6 +89,8 @@ func (c *VersionCollector) Collect(ch chan<- prometheus.Metric) {
 		versionToString(hypervisorVersion),
 		versionToString(libvirtVersion),
 	)
+
+	return nil
 }
 
 func versionToString(version uint32) string {
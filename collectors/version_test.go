@@ -0,0 +1,66 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+)
+
+func TestVersionCollector(t *testing.T) {
+	conn := newFakeConnection(&fakeConnect{
+		hypervisorType:    "QEMU",
+		hypervisorVersion: 8002002,
+		libVersion:        10000000,
+	})
+	c := NewVersionCollector(promslog.NewNopLogger(), conn)
+
+	expected := `
+# HELP libvirtd_info Version details for LibvirtD
+# TYPE libvirtd_info counter
+libvirtd_info{driver="QEMU",driver_version="8.2.2",version="10.0.0"} 1
+# HELP libvirtd_scrape_success whether the collector scrape succeeded
+# TYPE libvirtd_scrape_success gauge
+libvirtd_scrape_success{collector="version"} 1
+`
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected), "libvirtd_info", "libvirtd_scrape_success")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersionToString(t *testing.T) {
+	tests := []struct {
+		version  uint32
+		expected string
+	}{
+		{0, "0.0.0"},
+		{1002003, "1.2.3"},
+		{10000000, "10.0.0"},
+		{9010000, "9.10.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if got := versionToString(tt.version); got != tt.expected {
+				t.Errorf("versionToString(%d) = %q, want %q", tt.version, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+
+	"github.com/vexxhost/libvirtd_exporter/collectors"
+)
+
+// Config is the configuration file of the exporter.  Anything left out of
+// the file keeps the value given on the command line.
+type Config struct {
+	Libvirt     LibvirtConfig     `yaml:"libvirt"`
+	Probe       ProbeConfig       `yaml:"probe"`
+	Scrape      ScrapeConfig      `yaml:"scrape"`
+	Collectors  map[string]bool   `yaml:"collectors"`
+	DomainStats DomainStatsConfig `yaml:"domain_stats"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+}
+
+type LibvirtConfig struct {
+	URI  string `yaml:"uri"`
+	Nova bool   `yaml:"nova"`
+}
+
+type ProbeConfig struct {
+	AllowedTargets []string `yaml:"allowed_targets"`
+}
+
+type ScrapeConfig struct {
+	// Timeout bounds the scrapes that do not come with a timeout from
+	// Prometheus.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// TimeoutOffset is taken off the timeout given by Prometheus to leave
+	// time to send the response.
+	TimeoutOffset time.Duration `yaml:"timeout_offset"`
+
+	// PollInterval, if set, polls libvirt in the background on this
+	// interval and serves the last snapshot instead of scraping on demand.
+	PollInterval time.Duration `yaml:"poll_interval"`
+
+	// PollTimestamps stamps polled samples with the time of their poll.
+	PollTimestamps bool `yaml:"poll_timestamps"`
+}
+
+type DomainStatsConfig struct {
+	// NameLabel adds the domain name as a label to every domain metric.
+	NameLabel bool `yaml:"name_label"`
+
+	// Include and Exclude select the domains to scrape, a domain has to
+	// match every criterion set in Include and none of those in Exclude.
+	Include DomainMatcherConfig `yaml:"include"`
+	Exclude DomainMatcherConfig `yaml:"exclude"`
+
+	// NoWait gets the stats of domains without waiting on those busy with
+	// a job, such as a migration.
+	NoWait bool `yaml:"nowait"`
+
+	// BlockBacking reports the block stats of every layer of the backing
+	// chain of disks, labelled by their backing index.
+	BlockBacking bool `yaml:"block_backing"`
+
+	DirtyRate DirtyRateConfig `yaml:"dirtyrate"`
+}
+
+type DirtyRateConfig struct {
+	// CalcInterval, if set, starts a dirty rate calculation on running
+	// domains on this interval.
+	CalcInterval time.Duration `yaml:"calc_interval"`
+
+	// CalcPeriod is the period dirty rates are calculated over.
+	CalcPeriod time.Duration `yaml:"calc_period"`
+}
+
+type DomainMatcherConfig struct {
+	// Names is a regular expression matching the whole domain name.
+	Names        string   `yaml:"names"`
+	UUIDs        []string `yaml:"uuids"`
+	States       []string `yaml:"states"`
+	NovaProjects []string `yaml:"nova_projects"`
+	NovaFlavors  []string `yaml:"nova_flavors"`
+}
+
+type MetricsConfig struct {
+	// NamingScheme selects the names metrics are exported under.
+	NamingScheme collectors.NamingScheme `yaml:"naming_scheme"`
+}
+
+// configFromFlags returns the configuration given on the command line.
+func configFromFlags(opts *collectors.Options) *Config {
+	return &Config{
+		Libvirt: LibvirtConfig{
+			URI:  *libvirtURI,
+			Nova: *libvirtNova,
+		},
+		Probe: ProbeConfig{
+			AllowedTargets: slices.Clone(*probeTargets),
+		},
+		Scrape: ScrapeConfig{
+			Timeout:        *scrapeTimeout,
+			TimeoutOffset:  *scrapeTimeoutOffset,
+			PollInterval:   *scrapePollInterval,
+			PollTimestamps: *scrapePollTimestamps,
+		},
+		Collectors: maps.Clone(opts.Collectors),
+		DomainStats: DomainStatsConfig{
+			NameLabel: *domainNameLabel,
+			Include: DomainMatcherConfig{
+				Names: *domainIncludeNames,
+			},
+			Exclude: DomainMatcherConfig{
+				Names: *domainExcludeNames,
+			},
+			NoWait:       *domainStatsNoWait,
+			BlockBacking: *domainBlockBacking,
+			DirtyRate: DirtyRateConfig{
+				CalcInterval: *dirtyRateCalcInterval,
+				CalcPeriod:   *dirtyRateCalcPeriod,
+			},
+		},
+		Metrics: MetricsConfig{
+			NamingScheme: collectors.NamingScheme(*namingScheme),
+		},
+	}
+}
+
+// loadConfig reads the configuration file at path, if any, on top of the
+// command line configuration.
+func loadConfig(path string, opts *collectors.Options) (*Config, error) {
+	cfg := configFromFlags(opts)
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		// NOTE: Strict parsing rejects keys already in a map, the
+		//       collectors of the file are merged with the flags instead.
+		flagCollectors := cfg.Collectors
+		cfg.Collectors = nil
+
+		err = yaml.UnmarshalStrict(data, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+
+		cfg.Collectors = mergeCollectors(flagCollectors, cfg.Collectors)
+	}
+
+	if cfg.Scrape.Timeout <= 0 {
+		return nil, fmt.Errorf("scrape timeout must be positive, got %s", cfg.Scrape.Timeout)
+	}
+
+	if cfg.Scrape.PollInterval < 0 {
+		return nil, fmt.Errorf("scrape poll interval must not be negative, got %s", cfg.Scrape.PollInterval)
+	}
+
+	dirtyRate := cfg.DomainStats.DirtyRate
+	if dirtyRate.CalcInterval < 0 {
+		return nil, fmt.Errorf("dirty rate calculation interval must not be negative, got %s", dirtyRate.CalcInterval)
+	}
+
+	// NOTE: QEMU calculates dirty rates over whole seconds, up to a minute.
+	period := dirtyRate.CalcPeriod
+	if dirtyRate.CalcInterval > 0 && (period < time.Second || period > time.Minute || period%time.Second != 0) {
+		return nil, fmt.Errorf("dirty rate calculation period must be whole seconds between 1s and 60s, got %s", period)
+	}
+
+	_, err := collectors.ParseNamingScheme(string(cfg.Metrics.NamingScheme))
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range cfg.Collectors {
+		if !slices.Contains(collectors.Names(), name) {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+	}
+
+	enabled := &collectors.Options{Collectors: cfg.Collectors}
+	if dirtyRate.CalcInterval > 0 && !enabled.IsEnabled("domain_stats.dirtyrate") {
+		return nil, fmt.Errorf("dirty rate calculations need the domain_stats.dirtyrate collector enabled")
+	}
+
+	return cfg, nil
+}
+
+// mergeCollectors returns the collectors enabled on the command line
+// overridden by those set in the configuration file.
+func mergeCollectors(flags map[string]bool, file map[string]bool) map[string]bool {
+	merged := maps.Clone(flags)
+	if merged == nil {
+		merged = map[string]bool{}
+	}
+	maps.Copy(merged, file)
+
+	return merged
+}
+
+// Options returns the collector options for the configuration.
+func (c *Config) Options() (*collectors.Options, error) {
+	include, err := c.DomainStats.Include.matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	exclude, err := c.DomainStats.Exclude.matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	filter := collectors.DomainFilter{Include: include, Exclude: exclude}
+	err = filter.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &collectors.Options{
+		Collectors:            c.Collectors,
+		Nova:                  c.Libvirt.Nova,
+		DomainNameLabel:       c.DomainStats.NameLabel,
+		NamingScheme:          c.Metrics.NamingScheme,
+		DomainFilter:          filter,
+		DomainStatsNoWait:     c.DomainStats.NoWait,
+		DomainBlockBacking:    c.DomainStats.BlockBacking,
+		DirtyRateCalcInterval: c.DomainStats.DirtyRate.CalcInterval,
+		DirtyRateCalcPeriod:   c.DomainStats.DirtyRate.CalcPeriod,
+	}, nil
+}
+
+// AllowedTargets compiles the allowed probe target patterns, anchoring
+// them so that they have to match the whole URI.
+func (c *Config) AllowedTargets() ([]*regexp.Regexp, error) {
+	allowed := make([]*regexp.Regexp, 0, len(c.Probe.AllowedTargets))
+
+	for _, pattern := range c.Probe.AllowedTargets {
+		re, err := compileAnchored(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid probe target pattern %q: %w", pattern, err)
+		}
+
+		allowed = append(allowed, re)
+	}
+
+	return allowed, nil
+}
+
+func (m *DomainMatcherConfig) matcher() (collectors.DomainMatcher, error) {
+	matcher := collectors.DomainMatcher{
+		UUIDs:        m.UUIDs,
+		States:       m.States,
+		NovaProjects: m.NovaProjects,
+		NovaFlavors:  m.NovaFlavors,
+	}
+
+	if m.Names != "" {
+		re, err := compileAnchored(m.Names)
+		if err != nil {
+			return matcher, fmt.Errorf("invalid domain name pattern %q: %w", m.Names, err)
+		}
+
+		matcher.Names = re
+	}
+
+	return matcher, nil
+}
+
+// compileAnchored compiles a regular expression that has to match the whole
+// string.
+func compileAnchored(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
@@ -0,0 +1,233 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vexxhost/libvirtd_exporter/collectors"
+)
+
+// setTestFlags sets the command line flags to their defaults for the
+// duration of the test, as kingpin only applies them when parsing.
+func setTestFlags(t *testing.T) {
+	t.Helper()
+
+	uri, timeout, offset, noWait, scheme := *libvirtURI, *scrapeTimeout, *scrapeTimeoutOffset, *domainStatsNoWait, *namingScheme
+	t.Cleanup(func() {
+		*libvirtURI, *scrapeTimeout, *scrapeTimeoutOffset, *domainStatsNoWait, *namingScheme = uri, timeout, offset, noWait, scheme
+	})
+
+	*libvirtURI = "qemu:///system"
+	*scrapeTimeout = 10 * time.Second
+	*scrapeTimeoutOffset = 500 * time.Millisecond
+	*domainStatsNoWait = true
+	*namingScheme = string(collectors.NamingLegacy)
+}
+
+// writeTestConfig writes a configuration file and returns its path.
+func writeTestConfig(t *testing.T, config string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(config), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	setTestFlags(t)
+
+	opts := collectors.DefaultOptions()
+	opts.Collectors["domain_stats.block"] = false
+
+	tests := []struct {
+		name   string
+		config string
+		check  func(t *testing.T, cfg *Config)
+	}{
+		{
+			name:   "flags only",
+			config: "",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Libvirt.URI != "qemu:///system" {
+					t.Errorf("got URI %q, want the flag", cfg.Libvirt.URI)
+				}
+				if cfg.Scrape.Timeout != 10*time.Second {
+					t.Errorf("got timeout %s, want the flag", cfg.Scrape.Timeout)
+				}
+			},
+		},
+		{
+			name:   "file over flags",
+			config: "libvirt:\n  uri: test:///default\nscrape:\n  timeout: 5s\n",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Libvirt.URI != "test:///default" {
+					t.Errorf("got URI %q, want the file", cfg.Libvirt.URI)
+				}
+				if cfg.Scrape.Timeout != 5*time.Second {
+					t.Errorf("got timeout %s, want the file", cfg.Scrape.Timeout)
+				}
+				if cfg.Scrape.TimeoutOffset != 500*time.Millisecond {
+					t.Errorf("got timeout offset %s, want the flag", cfg.Scrape.TimeoutOffset)
+				}
+				if !cfg.DomainStats.NoWait {
+					t.Error("got nowait disabled, want the flag")
+				}
+			},
+		},
+		{
+			name:   "collectors merged with flags",
+			config: "collectors:\n  version: false\n",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Collectors["version"] {
+					t.Error("got the version collector enabled, want the file")
+				}
+				if cfg.Collectors["domain_stats.block"] {
+					t.Error("got the block stats enabled, want the flag")
+				}
+				if !cfg.Collectors["domain_stats"] {
+					t.Error("got the domain stats collector disabled, want the default")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := ""
+			if tt.config != "" {
+				path = writeTestConfig(t, tt.config)
+			}
+
+			cfg, err := loadConfig(path, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	setTestFlags(t)
+
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{
+			name:   "unknown field",
+			config: "libvirt:\n  url: test:///default\n",
+			err:    "field url not found",
+		},
+		{
+			name:   "unknown collector",
+			config: "collectors:\n  hypervisor: true\n",
+			err:    `unknown collector "hypervisor"`,
+		},
+		{
+			name:   "unknown naming scheme",
+			config: "metrics:\n  naming_scheme: camel\n",
+			err:    `unknown naming scheme "camel"`,
+		},
+		{
+			name:   "zero timeout",
+			config: "scrape:\n  timeout: 0s\n",
+			err:    "scrape timeout must be positive",
+		},
+		{
+			name:   "negative poll interval",
+			config: "scrape:\n  poll_interval: -1s\n",
+			err:    "scrape poll interval must not be negative",
+		},
+		{
+			name:   "dirty rate period out of range",
+			config: "domain_stats:\n  dirtyrate:\n    calc_interval: 5m\n    calc_period: 90s\n",
+			err:    "dirty rate calculation period must be whole seconds",
+		},
+		{
+			name:   "dirty rate collector disabled",
+			config: "domain_stats:\n  dirtyrate:\n    calc_interval: 5m\n    calc_period: 1s\n",
+			err:    "dirty rate calculations need the domain_stats.dirtyrate collector enabled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(writeTestConfig(t, tt.config), collectors.DefaultOptions())
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	_, err := loadConfig(filepath.Join(t.TempDir(), "missing.yaml"), collectors.DefaultOptions())
+	if !os.IsNotExist(err) {
+		t.Errorf("got error %v loading a missing file, want it not to exist", err)
+	}
+}
+
+func TestConfigInvalidPatterns(t *testing.T) {
+	setTestFlags(t)
+
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{
+			name:   "include names",
+			config: "domain_stats:\n  include:\n    names: 'instance-(['\n",
+			err:    "invalid domain name pattern",
+		},
+		{
+			name:   "exclude names",
+			config: "domain_stats:\n  exclude:\n    names: '*'\n",
+			err:    "invalid domain name pattern",
+		},
+		{
+			name:   "probe target",
+			config: "probe:\n  allowed_targets: ['qemu+ssh://(']\n",
+			err:    "invalid probe target pattern",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(writeTestConfig(t, tt.config), collectors.DefaultOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = cfg.Options()
+			if err == nil {
+				_, err = cfg.AllowedTargets()
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/vexxhost/libvirtd_exporter/collectors"
+)
+
+// exporter serves the metrics of the configured collectors, re-creating
+// them whenever the configuration is reloaded.  Connections live in the
+// pool so that they survive reloads.
+type exporter struct {
+	logger     *slog.Logger
+	configFile string
+	flagOpts   *collectors.Options
+	pool       connectionPool
+
+	reloadMu sync.Mutex
+	state    atomic.Pointer[exporterState]
+
+	// registry holds the metrics of the exporter itself, which are served
+	// as is even when polling.
+	registry *prometheus.Registry
+
+	ReloadSuccess   prometheus.Gauge
+	ReloadTimestamp prometheus.Gauge
+}
+
+// connectionPool hands out the connections to libvirt by URI, see
+// collectors.ConnectionPool.
+type connectionPool interface {
+	Get(uri string) *collectors.Connection
+	Keep(uri string)
+}
+
+// exporterState is everything derived from a single configuration, it is
+// swapped as a whole on reload.
+type exporterState struct {
+	config         *Config
+	opts           *collectors.Options
+	allowedTargets []*regexp.Regexp
+	collectors     []prometheus.Collector
+	poller         *collectors.Poller
+}
+
+func newExporter(
+	logger *slog.Logger, configFile string, flagOpts *collectors.Options, pool connectionPool,
+) *exporter {
+	e := &exporter{
+		logger:     logger,
+		configFile: configFile,
+		flagOpts:   flagOpts,
+		pool:       pool,
+
+		ReloadSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "libvirtd_exporter_config_last_reload_successful",
+			Help: "whether the last configuration reload succeeded",
+		}),
+		ReloadTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "libvirtd_exporter_config_last_reload_success_timestamp_seconds",
+			Help: "timestamp of the last successful configuration reload",
+		}),
+		registry: prometheus.NewRegistry(),
+	}
+
+	e.registry.MustRegister(e.ReloadSuccess, e.ReloadTimestamp)
+
+	return e
+}
+
+// reload loads the configuration and atomically swaps in collectors built
+// from it, keeping the previous ones if anything fails.
+func (e *exporter) reload() error {
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+
+	state, err := e.load()
+	if err != nil {
+		e.ReloadSuccess.Set(0)
+		return err
+	}
+
+	old := e.state.Load()
+	if old != nil && old.poller != nil {
+		old.poller.Stop()
+	}
+	if state.poller != nil {
+		state.poller.Start()
+	}
+
+	e.state.Store(state)
+	e.pool.Keep(state.config.Libvirt.URI)
+	e.ReloadSuccess.Set(1)
+	e.ReloadTimestamp.SetToCurrentTime()
+
+	for _, name := range collectors.Names() {
+		e.logger.Info("Collector", "name", name, "enabled", state.opts.IsEnabled(name))
+	}
+
+	return nil
+}
+
+func (e *exporter) load() (*exporterState, error) {
+	cfg, err := loadConfig(e.configFile, e.flagOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	allowedTargets, err := cfg.AllowedTargets()
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := cfg.Options()
+	if err != nil {
+		return nil, err
+	}
+
+	conn := e.pool.Get(cfg.Libvirt.URI)
+
+	cs := []prometheus.Collector{conn}
+	cs = append(cs, collectors.NewCollectors(e.logger, conn, opts)...)
+
+	// NOTE: Registries are built for every scrape, make sure these
+	//       collectors can be registered before swapping them in.
+	err = prometheus.NewRegistry().Register(&multiCollector{cs})
+	if err != nil {
+		return nil, err
+	}
+
+	state := &exporterState{
+		config:         cfg,
+		opts:           opts,
+		allowedTargets: allowedTargets,
+		collectors:     cs,
+	}
+
+	if cfg.Scrape.PollInterval > 0 {
+		state.poller = collectors.NewPoller(
+			e.logger, cs, cfg.Scrape.PollInterval, cfg.Scrape.Timeout, cfg.Scrape.PollTimestamps,
+		)
+	}
+
+	return state, nil
+}
+
+// connection returns the connection to the configured libvirt URI.
+func (e *exporter) connection() *collectors.Connection {
+	return e.pool.Get(e.state.Load().config.Libvirt.URI)
+}
+
+func (e *exporter) metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := e.state.Load()
+
+		if state.poller != nil {
+			gatherer := prometheus.Gatherers{state.poller, e.registry}
+			promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+			return
+		}
+
+		e.serve(w, r, state, state.collectors, e.registry)
+	})
+}
+
+// serve scrapes the collectors, bounding their calls to libvirt by the
+// timeout of the scrape, along with any extra gatherers.
+func (e *exporter) serve(
+	w http.ResponseWriter, r *http.Request, state *exporterState, cs []prometheus.Collector,
+	extra ...prometheus.Gatherer,
+) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeoutFromRequest(r, state.config.Scrape))
+	defer cancel()
+
+	reg := prometheus.NewRegistry()
+	for _, c := range cs {
+		err := reg.Register(collectors.WithContext(ctx, c))
+		if err != nil {
+			e.logger.Error("Error registering collector", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	gatherers := append(prometheus.Gatherers{reg}, extra...)
+	promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// timeoutFromRequest returns the timeout Prometheus sent with the scrape, minus
+// the configured offset, or the configured timeout if it did not send one.
+func timeoutFromRequest(r *http.Request, cfg ScrapeConfig) time.Duration {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if header == "" {
+		return cfg.Timeout
+	}
+
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || seconds <= 0 {
+		return cfg.Timeout
+	}
+
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > cfg.TimeoutOffset {
+		timeout -= cfg.TimeoutOffset
+	}
+
+	return timeout
+}
+
+// multiCollector groups collectors so that they can be checked at once.
+type multiCollector struct {
+	collectors []prometheus.Collector
+}
+
+func (m *multiCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors {
+		c.Describe(ch)
+	}
+}
+
+func (m *multiCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors {
+		c.Collect(ch)
+	}
+}
+
+func (e *exporter) reloadHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		err := e.reload()
+		if err != nil {
+			e.logger.Error("Error reloading configuration", "err", err)
+			http.Error(w, "Failed to reload configuration: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		e.logger.Info("Reloaded configuration", "file", e.configFile)
+	})
+}
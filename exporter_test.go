@@ -0,0 +1,186 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+
+	"github.com/vexxhost/libvirtd_exporter/collectors"
+)
+
+func TestExporterReload(t *testing.T) {
+	setTestFlags(t)
+
+	path := writeTestConfig(t, "libvirt:\n  uri: test:///default\n")
+
+	logger := promslog.NewNopLogger()
+	e := newExporter(logger, path, collectors.DefaultOptions(), collectors.NewConnectionPool(logger, collectors.ConnectionOptions{}))
+
+	err := e.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := testutil.ToFloat64(e.ReloadSuccess); value != 1 {
+		t.Errorf("last reload successful is %v after a valid configuration, want 1", value)
+	}
+
+	state := e.state.Load()
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"invalid yaml", "libvirt: ["},
+		{"unknown collector", "collectors:\n  hypervisor: true\n"},
+		{"invalid pattern", "domain_stats:\n  include:\n    names: '('\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := os.WriteFile(path, []byte(tt.config), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = e.reload()
+			if err == nil {
+				t.Fatal("reloaded an invalid configuration")
+			}
+
+			if e.state.Load() != state {
+				t.Error("swapped the state for an invalid configuration")
+			}
+			if value := testutil.ToFloat64(e.ReloadSuccess); value != 0 {
+				t.Errorf("last reload successful is %v after an invalid configuration, want 0", value)
+			}
+		})
+	}
+
+	err = os.WriteFile(path, []byte("libvirt:\n  uri: test:///other\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = e.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri := e.connection().URI(); uri != "test:///other" {
+		t.Errorf("connected to %q after reloading, want test:///other", uri)
+	}
+	if value := testutil.ToFloat64(e.ReloadSuccess); value != 1 {
+		t.Errorf("last reload successful is %v after recovering, want 1", value)
+	}
+}
+
+func TestTimeoutFromRequest(t *testing.T) {
+	cfg := ScrapeConfig{
+		Timeout:       10 * time.Second,
+		TimeoutOffset: 500 * time.Millisecond,
+	}
+
+	tests := []struct {
+		name    string
+		header  string
+		timeout time.Duration
+	}{
+		{"no header", "", 10 * time.Second},
+		{"header minus offset", "15", 14500 * time.Millisecond},
+		{"fractional header", "2.5", 2 * time.Second},
+		{"header within offset", "0.25", 250 * time.Millisecond},
+		{"invalid header", "soon", 10 * time.Second},
+		{"zero header", "0", 10 * time.Second},
+		{"negative header", "-5", 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tt.header)
+			}
+
+			if timeout := timeoutFromRequest(r, cfg); timeout != tt.timeout {
+				t.Errorf("got timeout %s, want %s", timeout, tt.timeout)
+			}
+		})
+	}
+}
+
+// deadlineCollector records the deadline it is scraped with.
+type deadlineCollector struct {
+	deadline time.Time
+}
+
+func (c *deadlineCollector) Describe(_ chan<- *prometheus.Desc) {}
+
+func (c *deadlineCollector) Collect(_ chan<- prometheus.Metric) {}
+
+func (c *deadlineCollector) CollectWithContext(ctx context.Context, _ chan<- prometheus.Metric) {
+	c.deadline, _ = ctx.Deadline()
+}
+
+func TestExporterServeDeadline(t *testing.T) {
+	state := &exporterState{
+		config: &Config{
+			Scrape: ScrapeConfig{
+				Timeout:       10 * time.Second,
+				TimeoutOffset: 500 * time.Millisecond,
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		header  string
+		timeout time.Duration
+	}{
+		{"fallback", "", 10 * time.Second},
+		{"header", "3", 2500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &deadlineCollector{}
+			e := newExporter(promslog.NewNopLogger(), "", collectors.DefaultOptions(), nil)
+
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tt.header)
+			}
+
+			start := time.Now()
+			e.serve(httptest.NewRecorder(), r, state, []prometheus.Collector{c})
+			end := time.Now()
+
+			if c.deadline.IsZero() {
+				t.Fatal("scraped the collector without a deadline")
+			}
+
+			if c.deadline.Before(start.Add(tt.timeout)) || c.deadline.After(end.Add(tt.timeout)) {
+				t.Errorf("scraped the collector with a timeout of %s, want %s", c.deadline.Sub(start), tt.timeout)
+			}
+		})
+	}
+}
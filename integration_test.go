@@ -0,0 +1,412 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/promslog"
+
+	"github.com/vexxhost/libvirtd_exporter/collectors"
+)
+
+// These tests run the exporter against the libvirt test driver, they are
+// skipped when libvirt cannot be loaded.
+
+// testDefaultUUID is the UUID of the domain of test:///default.
+const testDefaultUUID = "6695eb01-f6a4-8304-79aa-97f2502e193f"
+
+type expectedFamily struct {
+	typ    dto.MetricType
+	labels []string
+}
+
+var (
+	counter = dto.MetricType_COUNTER
+	gauge   = dto.MetricType_GAUGE
+)
+
+// expectedFamilies are all the metric families the exporter may emit.
+var expectedFamilies = map[string]expectedFamily{
+	"libvirtd_up":                      {gauge, nil},
+	"libvirtd_scrape_errors_total":     {counter, []string{"collector", "code"}},
+	"libvirtd_scrape_duration_seconds": {gauge, []string{"collector"}},
+	"libvirtd_scrape_success":          {gauge, []string{"collector"}},
+	"libvirtd_scrape_timeout":          {gauge, []string{"collector"}},
+
+	"libvirtd_exporter_config_last_reload_successful":                {gauge, nil},
+	"libvirtd_exporter_config_last_reload_success_timestamp_seconds": {gauge, nil},
+
+	"libvirtd_info": {counter, []string{"driver", "driver_version", "version"}},
+
+	"libvirtd_domain_info": {gauge, []string{"uuid", "name", "title", "os_type", "machine_type", "hypervisor_type"}},
+
+	"libvirtd_domain_seconds":             {counter, []string{"uuid", "instance_type", "user_id", "project_id"}},
+	"libvirtd_domain_stats_incomplete":    {gauge, []string{"uuid"}},
+	"libvirtd_domain_state":               {gauge, []string{"uuid", "state"}},
+	"libvirtd_domain_state_reason_info":   {gauge, []string{"uuid", "state", "reason"}},
+	"libvirtd_domain_domain_state":        {gauge, []string{"uuid"}},
+	"libvirtd_domain_domain_state_reason": {gauge, []string{"uuid"}},
+
+	"libvirtd_domain_cpu_time":   {counter, []string{"uuid"}},
+	"libvirtd_domain_cpu_user":   {counter, []string{"uuid"}},
+	"libvirtd_domain_cpu_system": {counter, []string{"uuid"}},
+
+	"libvirtd_domain_balloon_current":         {gauge, []string{"uuid"}},
+	"libvirtd_domain_balloon_maximum":         {gauge, []string{"uuid"}},
+	"libvirtd_domain_balloon_swap_in":         {counter, []string{"uuid"}},
+	"libvirtd_domain_balloon_swap_out":        {counter, []string{"uuid"}},
+	"libvirtd_domain_balloon_major_fault":     {counter, []string{"uuid"}},
+	"libvirtd_domain_balloon_minor_fault":     {counter, []string{"uuid"}},
+	"libvirtd_domain_balloon_unused":          {gauge, []string{"uuid"}},
+	"libvirtd_domain_balloon_available":       {gauge, []string{"uuid"}},
+	"libvirtd_domain_balloon_rss":             {gauge, []string{"uuid"}},
+	"libvirtd_domain_balloon_usable":          {gauge, []string{"uuid"}},
+	"libvirtd_domain_balloon_disk_caches":     {gauge, []string{"uuid"}},
+	"libvirtd_domain_balloon_hugetlb_pgalloc": {counter, []string{"uuid"}},
+	"libvirtd_domain_balloon_hugetlb_pgfail":  {counter, []string{"uuid"}},
+
+	"libvirtd_domain_vcpu_state":               {gauge, []string{"uuid", "vcpu"}},
+	"libvirtd_domain_vcpu_time":                {counter, []string{"uuid", "vcpu"}},
+	"libvirtd_domain_vcpu_wait_seconds_total":  {counter, []string{"uuid", "vcpu"}},
+	"libvirtd_domain_vcpu_delay_seconds_total": {counter, []string{"uuid", "vcpu"}},
+	"libvirtd_domain_vcpu_halted":              {gauge, []string{"uuid", "vcpu"}},
+	"libvirtd_domain_cpu_delay_seconds_total":  {counter, []string{"uuid"}},
+
+	"libvirtd_domain_interface_info": {gauge, []string{
+		"uuid", "interface", "mac", "source_bridge", "source_network", "source_portgroup", "model", "vlan", "interface_id",
+	}},
+	"libvirtd_domain_net_rx_bytes":   {counter, []string{"uuid", "interface"}},
+	"libvirtd_domain_net_rx_packets": {counter, []string{"uuid", "interface"}},
+	"libvirtd_domain_net_rx_errors":  {counter, []string{"uuid", "interface"}},
+	"libvirtd_domain_net_rx_drop":    {counter, []string{"uuid", "interface"}},
+	"libvirtd_domain_net_tx_bytes":   {counter, []string{"uuid", "interface"}},
+	"libvirtd_domain_net_tx_packets": {counter, []string{"uuid", "interface"}},
+	"libvirtd_domain_net_tx_errors":  {counter, []string{"uuid", "interface"}},
+	"libvirtd_domain_net_tx_drop":    {gauge, []string{"uuid", "interface"}},
+
+	"libvirtd_domain_block_info":            {gauge, []string{"uuid", "device", "bus", "format", "cache", "io", "discard", "serial", "iothread"}},
+	"libvirtd_domain_block_errors":          {gauge, []string{"uuid", "device", "error"}},
+	"libvirtd_domain_block_threshold_bytes": {gauge, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_read_requests":   {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_read_bytes":      {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_read_times":      {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_write_requests":  {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_write_bytes":     {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_write_times":     {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_flush_requests":  {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_flush_times":     {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_allocation":      {gauge, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_capacity":        {gauge, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_physical":        {gauge, []string{"uuid", "device", "path"}},
+
+	// Conventional names, see collectors.NamingConventional.
+	"libvirtd_domain_cpu_seconds_total":             {counter, []string{"uuid"}},
+	"libvirtd_domain_cpu_user_seconds_total":        {counter, []string{"uuid"}},
+	"libvirtd_domain_cpu_system_seconds_total":      {counter, []string{"uuid"}},
+	"libvirtd_domain_balloon_current_bytes":         {gauge, []string{"uuid"}},
+	"libvirtd_domain_balloon_maximum_bytes":         {gauge, []string{"uuid"}},
+	"libvirtd_domain_balloon_swap_in_bytes_total":   {counter, []string{"uuid"}},
+	"libvirtd_domain_balloon_swap_out_bytes_total":  {counter, []string{"uuid"}},
+	"libvirtd_domain_balloon_major_faults_total":    {counter, []string{"uuid"}},
+	"libvirtd_domain_balloon_minor_faults_total":    {counter, []string{"uuid"}},
+	"libvirtd_domain_balloon_unused_bytes":          {gauge, []string{"uuid"}},
+	"libvirtd_domain_balloon_available_bytes":       {gauge, []string{"uuid"}},
+	"libvirtd_domain_balloon_rss_bytes":             {gauge, []string{"uuid"}},
+	"libvirtd_domain_balloon_usable_bytes":          {gauge, []string{"uuid"}},
+	"libvirtd_domain_balloon_disk_caches_bytes":     {gauge, []string{"uuid"}},
+	"libvirtd_domain_balloon_hugetlb_pgalloc_total": {counter, []string{"uuid"}},
+	"libvirtd_domain_balloon_hugetlb_pgfail_total":  {counter, []string{"uuid"}},
+	"libvirtd_domain_vcpu_seconds_total":            {counter, []string{"uuid", "vcpu"}},
+	"libvirtd_domain_net_rx_bytes_total":            {counter, []string{"uuid", "interface"}},
+	"libvirtd_domain_net_rx_packets_total":          {counter, []string{"uuid", "interface"}},
+	"libvirtd_domain_net_rx_errors_total":           {counter, []string{"uuid", "interface"}},
+	"libvirtd_domain_net_rx_drops_total":            {counter, []string{"uuid", "interface"}},
+	"libvirtd_domain_net_tx_bytes_total":            {counter, []string{"uuid", "interface"}},
+	"libvirtd_domain_net_tx_packets_total":          {counter, []string{"uuid", "interface"}},
+	"libvirtd_domain_net_tx_errors_total":           {counter, []string{"uuid", "interface"}},
+	"libvirtd_domain_net_tx_drops_total":            {counter, []string{"uuid", "interface"}},
+	"libvirtd_domain_block_read_requests_total":     {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_read_bytes_total":        {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_read_seconds_total":      {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_write_requests_total":    {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_write_bytes_total":       {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_write_seconds_total":     {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_flush_requests_total":    {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_flush_seconds_total":     {counter, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_allocation_bytes":        {gauge, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_capacity_bytes":          {gauge, []string{"uuid", "device", "path"}},
+	"libvirtd_domain_block_physical_bytes":          {gauge, []string{"uuid", "device", "path"}},
+
+	"libvirtd_domain_perf_cmt_bytes":                     {gauge, []string{"uuid"}},
+	"libvirtd_domain_perf_mbmt_bytes_per_second":         {gauge, []string{"uuid"}},
+	"libvirtd_domain_perf_mbml_bytes_per_second":         {gauge, []string{"uuid"}},
+	"libvirtd_domain_perf_cache_misses_total":            {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_cache_references_total":        {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_instructions_total":            {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_cpu_cycles_total":              {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_branch_instructions_total":     {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_branch_misses_total":           {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_bus_cycles_total":              {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_stalled_cycles_frontend_total": {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_stalled_cycles_backend_total":  {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_ref_cpu_cycles_total":          {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_cpu_clock_seconds_total":       {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_task_clock_seconds_total":      {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_page_faults_total":             {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_context_switches_total":        {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_cpu_migrations_total":          {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_minor_page_faults_total":       {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_major_page_faults_total":       {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_alignment_faults_total":        {counter, []string{"uuid"}},
+	"libvirtd_domain_perf_emulation_faults_total":        {counter, []string{"uuid"}},
+
+	"libvirtd_domain_iothread_info":             {gauge, []string{"uuid", "iothread", "cpus"}},
+	"libvirtd_domain_iothread_poll_max_seconds": {gauge, []string{"uuid", "iothread"}},
+	"libvirtd_domain_iothread_poll_grow":        {gauge, []string{"uuid", "iothread"}},
+	"libvirtd_domain_iothread_poll_shrink":      {gauge, []string{"uuid", "iothread"}},
+
+	"libvirtd_domain_cpu_cache_monitor_bytes":            {gauge, []string{"uuid", "monitor", "vcpus", "bank"}},
+	"libvirtd_domain_memory_bandwidth_local_bytes_total": {counter, []string{"uuid", "monitor", "vcpus", "node"}},
+	"libvirtd_domain_memory_bandwidth_bytes_total":       {counter, []string{"uuid", "monitor", "vcpus", "node"}},
+
+	"libvirtd_domain_dirtyrate_calc_status":             {gauge, []string{"uuid", "status"}},
+	"libvirtd_domain_dirtyrate_calc_start_time_seconds": {gauge, []string{"uuid"}},
+	"libvirtd_domain_dirtyrate_calc_period_seconds":     {gauge, []string{"uuid"}},
+	"libvirtd_domain_dirtyrate_bytes_per_second":        {gauge, []string{"uuid"}},
+	"libvirtd_domain_dirtyrate_vcpu_bytes_per_second":   {gauge, []string{"uuid", "vcpu"}},
+}
+
+// newTestServer serves the exporter configured with the given
+// configuration file.
+func newTestServer(t *testing.T, config string) *httptest.Server {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(config), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := promslog.NewNopLogger()
+	pool := collectors.NewConnectionPool(logger, collectors.ConnectionOptions{})
+
+	e := newExporter(logger, path, collectors.DefaultOptions(), pool)
+	err = e.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := e.connection().Connect()
+	if err != nil {
+		t.Skipf("libvirt is not available: %v", err)
+	}
+	_, err = conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		err := e.connection().Close()
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	handler, err := e.handler("/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// testConfig returns a configuration file scraping uri, exporting metrics
+// under every name.
+func testConfig(uri string) string {
+	return "libvirt:\n  uri: " + uri + "\nscrape:\n  timeout: 10s\nmetrics:\n  naming_scheme: both\n"
+}
+
+// scrape fetches and parses the metrics served at url.
+func scrape(t *testing.T, url string) map[string]*dto.MetricFamily {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s returned %s", url, resp.Status)
+	}
+
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to parse metrics: %v", err)
+	}
+
+	return families
+}
+
+// validateFamilies checks that every family is known and has the expected
+// type and labels, and that libvirt was scraped successfully.
+func validateFamilies(t *testing.T, families map[string]*dto.MetricFamily) {
+	t.Helper()
+
+	for name, family := range families {
+		expected, ok := expectedFamilies[name]
+		if !ok {
+			t.Errorf("unexpected metric family %s", name)
+			continue
+		}
+
+		if family.GetType() != expected.typ {
+			t.Errorf("%s has type %s, want %s", name, family.GetType(), expected.typ)
+		}
+
+		for _, metric := range family.Metric {
+			var labels []string
+			for _, label := range metric.Label {
+				labels = append(labels, label.GetName())
+			}
+
+			if !sameLabels(labels, expected.labels) {
+				t.Errorf("%s has labels %v, want %v", name, labels, expected.labels)
+			}
+		}
+	}
+
+	for _, name := range []string{"libvirtd_up", "libvirtd_scrape_success"} {
+		family, ok := families[name]
+		if !ok {
+			t.Fatalf("missing metric family %s", name)
+		}
+
+		for _, metric := range family.Metric {
+			if metric.GetGauge().GetValue() != 1 {
+				t.Errorf("%s%v is %v, want 1", name, metric.Label, metric.GetGauge().GetValue())
+			}
+		}
+	}
+
+	if _, ok := families["libvirtd_info"]; !ok {
+		t.Error("missing metric family libvirtd_info")
+	}
+}
+
+// validateDomains checks that the state of exactly the given domains is
+// reported.
+func validateDomains(t *testing.T, families map[string]*dto.MetricFamily, uuids []string) {
+	t.Helper()
+
+	family, ok := families["libvirtd_domain_domain_state"]
+	if !ok {
+		t.Fatal("missing metric family libvirtd_domain_domain_state")
+	}
+
+	var got []string
+	for _, metric := range family.Metric {
+		got = append(got, labelValue(metric, "uuid"))
+	}
+
+	if !sameLabels(got, uuids) {
+		t.Errorf("got domains %v, want %v", got, uuids)
+	}
+}
+
+func labelValue(metric *dto.Metric, name string) string {
+	for _, label := range metric.Label {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+
+	return ""
+}
+
+func sameLabels(a, b []string) bool {
+	a = slices.Sorted(slices.Values(a))
+	b = slices.Sorted(slices.Values(b))
+
+	return slices.Equal(a, b)
+}
+
+func TestIntegrationMetrics(t *testing.T) {
+	fixture, err := filepath.Abs("testdata/multiple-domains.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		uri   string
+		uuids []string
+	}{
+		{
+			name:  "default",
+			uri:   "test:///default",
+			uuids: []string{testDefaultUUID},
+		},
+		{
+			name: "multiple domains",
+			uri:  "test://" + fixture,
+			uuids: []string{
+				"0b1f7d5e-3c2a-4f6e-9a1b-7c8d9e0f1a2b",
+				"5e4d3c2b-1a09-4f8e-b7c6-d5e4f3a2b1c0",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, testConfig(tt.uri))
+
+			families := scrape(t, srv.URL+"/metrics")
+			validateFamilies(t, families)
+			validateDomains(t, families, tt.uuids)
+		})
+	}
+}
+
+func TestIntegrationProbe(t *testing.T) {
+	srv := newTestServer(t, testConfig("test:///default")+"probe:\n  allowed_targets: ['test:///default']\n")
+
+	families := scrape(t, srv.URL+"/probe?target="+url.QueryEscape("test:///default"))
+	validateFamilies(t, families)
+	validateDomains(t, families, []string{testDefaultUUID})
+
+	resp, err := http.Get(srv.URL + "/probe?target=" + url.QueryEscape("qemu:///system"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("probing a target not allowed returned %s, want %d", resp.Status, http.StatusForbidden)
+	}
+}
@@ -15,19 +15,17 @@
 package main
 
 import (
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/alecthomas/kingpin/v2"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/promslog"
 	"github.com/prometheus/common/promslog/flag"
 	"github.com/prometheus/common/version"
 	"github.com/prometheus/exporter-toolkit/web"
 	webflag "github.com/prometheus/exporter-toolkit/web/kingpinflag"
-	"libvirt.org/go/libvirt"
 
 	"github.com/vexxhost/libvirtd_exporter/collectors"
 )
@@ -46,12 +44,81 @@ var (
 		"libvirt.nova",
 		"Parse Libvirt Nova metadata",
 	).Bool()
+	domainNameLabel = kingpin.Flag(
+		"collector.domain_stats.name-label",
+		"Add the domain name as a label to every domain metric",
+	).Bool()
+	domainIncludeNames = kingpin.Flag(
+		"collector.domain_stats.include-names",
+		"Regular expression of the domain names to scrape",
+	).String()
+	domainExcludeNames = kingpin.Flag(
+		"collector.domain_stats.exclude-names",
+		"Regular expression of the domain names not to scrape",
+	).String()
+	domainStatsNoWait = kingpin.Flag(
+		"collector.domain_stats.nowait",
+		"Do not wait on domains busy with a job, such as a migration, and report their stats as incomplete",
+	).Default("true").Bool()
+	domainBlockBacking = kingpin.Flag(
+		"collector.domain_stats.block-backing",
+		"Report the block stats of every layer of the backing chain of disks",
+	).Bool()
+	dirtyRateCalcInterval = kingpin.Flag(
+		"collector.domain_stats.dirtyrate.calc-interval",
+		"Start a dirty rate calculation on running domains on this interval, 0 disables it",
+	).Default("0s").Duration()
+	dirtyRateCalcPeriod = kingpin.Flag(
+		"collector.domain_stats.dirtyrate.calc-period",
+		"Period dirty rates are calculated over, between 1s and 60s",
+	).Default("1s").Duration()
+	namingScheme = kingpin.Flag(
+		"metrics.naming-scheme",
+		"Names to export metrics under: legacy, conventional names in base units, or both while migrating",
+	).Default(string(collectors.NamingLegacy)).Enum(
+		string(collectors.NamingLegacy), string(collectors.NamingConventional), string(collectors.NamingBoth),
+	)
+	libvirtReadOnly = kingpin.Flag(
+		"libvirt.readonly",
+		"Open a read-only connection to Libvirt",
+	).Bool()
+	libvirtAuthFile = kingpin.Flag(
+		"libvirt.auth-file",
+		"YAML file with the username and password used to authenticate against Libvirt",
+	).String()
+	scrapeTimeout = kingpin.Flag(
+		"scrape.timeout",
+		"Timeout for scrapes that do not send a X-Prometheus-Scrape-Timeout-Seconds header",
+	).Default("10s").Duration()
+	scrapeTimeoutOffset = kingpin.Flag(
+		"scrape.timeout-offset",
+		"Offset to subtract from the timeout sent by Prometheus",
+	).Default("500ms").Duration()
+	scrapePollInterval = kingpin.Flag(
+		"scrape.poll-interval",
+		"Poll Libvirt in the background on this interval and serve the last snapshot, 0 scrapes on demand",
+	).Default("0s").Duration()
+	scrapePollTimestamps = kingpin.Flag(
+		"scrape.poll-timestamps",
+		"Attach the time of the poll to the samples served in polling mode",
+	).Bool()
+	configFile = kingpin.Flag(
+		"config.file",
+		"Path to the configuration file, reloaded on SIGHUP or a POST to /-/reload",
+	).String()
+	probeTargets = kingpin.Flag(
+		"probe.allowed-target",
+		"Regular expression of libvirt URIs that may be scraped through /probe, can be repeated",
+	).Strings()
 )
 
 func main() {
 	promlogConfig := &promslog.Config{}
 	flag.AddFlags(kingpin.CommandLine, promlogConfig)
 
+	opts := collectors.DefaultOptions()
+	collectors.AddFlags(kingpin.CommandLine, opts)
+
 	kingpin.Version(version.Print("libvirtd_exporter"))
 	kingpin.HelpFlag.Short('h')
 	kingpin.Parse()
@@ -61,42 +128,94 @@ func main() {
 	logger.With("version", version.Info()).Info("Starting libvirtd_exporter")
 	logger.With("build_context", version.BuildContext()).Info("Build context")
 
-	conn, err := libvirt.NewConnect(*libvirtURI)
+	credentials, err := collectors.LoadCredentials(*libvirtAuthFile)
 	if err != nil {
-		log.Fatalln(err)
-		return
+		logger.Error("Error loading Libvirt credentials", "err", err)
+		os.Exit(1)
 	}
 
-	reg := prometheus.NewRegistry()
-	reg.MustRegister(
-		collectors.NewVersionCollector(logger, conn),
-		collectors.NewDomainStatsCollector(logger, conn, *libvirtNova),
-	)
+	pool := collectors.NewConnectionPool(logger, collectors.ConnectionOptions{
+		ReadOnly:    *libvirtReadOnly,
+		Credentials: credentials,
+	})
+
+	e := newExporter(logger, *configFile, opts, pool)
+	err = e.reload()
+	if err != nil {
+		logger.Error("Error loading configuration", "err", err)
+		os.Exit(1)
+	}
+
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		for range hup {
+			err := e.reload()
+			if err != nil {
+				logger.Error("Error reloading configuration", "err", err)
+				continue
+			}
+
+			logger.Info("Reloaded configuration", "file", *configFile)
+		}
+	}()
+
+	c, err := e.connection().Connect()
+	switch {
+	// NOTE: An exporter probing remote hypervisors may run where there is
+	//       no libvirt to connect to.
+	case err != nil && len(e.state.Load().allowedTargets) > 0:
+		logger.Warn("Failed to connect to Libvirt, only probes can succeed", "uri", e.connection().URI(), "err", err)
+	case err != nil:
+		logger.Error("Error connecting to Libvirt", "uri", e.connection().URI(), "err", err)
+		os.Exit(1)
+	default:
+		_, err = c.Close()
+		if err != nil {
+			logger.Error("Failed to close connection", "err", err)
+		}
+	}
+
+	handler, err := e.handler(*metricsPath)
+	if err != nil {
+		logger.Error("Error creating landing page", "err", err)
+		os.Exit(1)
+	}
+
+	srv := &http.Server{Handler: handler}
+	if err := web.ListenAndServe(srv, toolkitFlags, logger); err != nil {
+		logger.Error("Error starting HTTP server", "err", err)
+		os.Exit(1)
+	}
+}
+
+// handler routes the endpoints of the exporter, with the metrics served
+// under metricsPath.
+func (e *exporter) handler(metricsPath string) (http.Handler, error) {
+	mux := http.NewServeMux()
 
-	http.Handle(*metricsPath, promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg}))
-	if *metricsPath != "/" && *metricsPath != "" {
+	mux.Handle(metricsPath, e.metricsHandler())
+	mux.Handle("/probe", e.probeHandler())
+	mux.Handle("/-/reload", e.reloadHandler())
+	if metricsPath != "/" && metricsPath != "" {
 		landingConfig := web.LandingConfig{
 			Name:        "LibvirtD Exporter",
 			Description: "Prometheus Exporter for LibvirtD",
 			Version:     version.Info(),
 			Links: []web.LandingLinks{
 				{
-					Address: *metricsPath,
+					Address: metricsPath,
 					Text:    "Metrics",
 				},
 			},
 		}
 		landingPage, err := web.NewLandingPage(landingConfig)
 		if err != nil {
-			logger.Error("Error creating landing page", "err", err)
-			os.Exit(1)
+			return nil, err
 		}
-		http.Handle("/", landingPage)
+		mux.Handle("/", landingPage)
 	}
 
-	srv := &http.Server{}
-	if err := web.ListenAndServe(srv, toolkitFlags, logger); err != nil {
-		logger.Error("Error starting HTTP server", "err", err)
-		os.Exit(1)
-	}
+	return mux, nil
 }
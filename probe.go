@@ -0,0 +1,64 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/vexxhost/libvirtd_exporter/collectors"
+)
+
+// probeHandler scrapes the libvirt URI given in the target parameter, much
+// like the blackbox exporter, so that a single exporter can cover a fleet
+// of hypervisors.  Only targets matching one of the allowed patterns are
+// accepted since a libvirt URI can spawn arbitrary transports.
+func (e *exporter) probeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := e.state.Load()
+
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+			return
+		}
+
+		if !targetAllowed(target, state.allowedTargets) {
+			e.logger.Warn("Rejected probe of target not in the allowlist", "target", target)
+			http.Error(w, fmt.Sprintf("Target %q is not allowed", target), http.StatusForbidden)
+			return
+		}
+
+		conn := e.pool.Get(target)
+
+		cs := []prometheus.Collector{conn}
+		cs = append(cs, collectors.NewCollectors(e.logger.With("target", target), conn, state.opts)...)
+
+		e.serve(w, r, state, cs)
+	})
+}
+
+func targetAllowed(target string, allowed []*regexp.Regexp) bool {
+	for _, re := range allowed {
+		if re.MatchString(target) {
+			return true
+		}
+	}
+
+	return false
+}
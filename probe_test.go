@@ -0,0 +1,116 @@
+// Copyright 2019 VEXXHOST, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/promslog"
+
+	"github.com/vexxhost/libvirtd_exporter/collectors"
+)
+
+// stubPool records the URIs connections are asked for.
+type stubPool struct {
+	uris []string
+}
+
+func (p *stubPool) Get(uri string) *collectors.Connection {
+	p.uris = append(p.uris, uri)
+
+	return collectors.NewConnection(promslog.NewNopLogger(), uri, collectors.ConnectionOptions{})
+}
+
+func (p *stubPool) Keep(_ string) {}
+
+func TestProbeHandler(t *testing.T) {
+	// NOTE: Only the connection itself is scraped, bounded by a short
+	//       timeout in case libvirt is there.
+	opts := collectors.DefaultOptions()
+	for _, name := range collectors.Names() {
+		opts.Collectors[name] = false
+	}
+
+	newState := func(patterns ...string) *exporterState {
+		allowed := []*regexp.Regexp{}
+		for _, pattern := range patterns {
+			re, err := compileAnchored(pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			allowed = append(allowed, re)
+		}
+
+		return &exporterState{
+			config:         &Config{Scrape: ScrapeConfig{Timeout: time.Second}},
+			opts:           opts,
+			allowedTargets: allowed,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		allowed []string
+		target  string
+		status  int
+	}{
+		{"allowed", []string{`test:///default`}, "test:///default", http.StatusOK},
+		{"allowed by any pattern", []string{`qemu:///system`, `test:///[a-z]+`}, "test:///default", http.StatusOK},
+		{"missing target", []string{`test:///default`}, "", http.StatusBadRequest},
+		{"not allowed", []string{`test:///default`}, "qemu:///system", http.StatusForbidden},
+		{"partial match of the suffix", []string{`test:///default`}, "test:///default/../etc", http.StatusForbidden},
+		{"partial match of the prefix", []string{`test:///default`}, "ext+test:///default", http.StatusForbidden},
+		{"no allowlist", nil, "test:///default", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &stubPool{}
+			e := newExporter(promslog.NewNopLogger(), "", opts, pool)
+			e.state.Store(newState(tt.allowed...))
+
+			path := "/probe"
+			if tt.target != "" {
+				path += "?target=" + url.QueryEscape(tt.target)
+			}
+
+			w := httptest.NewRecorder()
+			e.probeHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+			if w.Code != tt.status {
+				t.Errorf("got status %d, want %d", w.Code, tt.status)
+			}
+
+			var uris []string
+			if tt.status == http.StatusOK {
+				uris = []string{tt.target}
+
+				if !strings.Contains(w.Body.String(), "libvirtd_up ") {
+					t.Errorf("probe did not report whether %s is up", tt.target)
+				}
+			}
+			if !slices.Equal(pool.uris, uris) {
+				t.Errorf("connected to %v, want %v", pool.uris, uris)
+			}
+		})
+	}
+}